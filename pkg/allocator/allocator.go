@@ -0,0 +1,240 @@
+package allocator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+const (
+	lockFileName        = "vf-allocator.lock"
+	reservationFileName = "vf-reservations.json"
+	vlanLeaseFileName   = "vlan-leases.json"
+	pfMTUFileName       = "pf-mtus.json"
+)
+
+// Reservation records the attachment owning a VF
+type Reservation struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// PfMTU records the MTU a PF had before ADD raised it
+type PfMTU struct {
+	Orig   int `json:"orig"`
+	Raised int `json:"raised"`
+}
+
+// Allocator records which VFs and pool VLANs of the node are owned by which
+// attachment, and the original MTU of the PFs raised by ADD. The VLAN leases
+// are kept per network name, the pools of different networks are independent. The reservations
+// are shared by all the plugin instances of the node through files under the
+// data dir, Open serializes them with an exclusive flock.
+type Allocator struct {
+	dataDir      string
+	lock         *os.File
+	reservations map[string]Reservation
+	vlanLeases   map[string]map[int]Reservation
+	pfMTUs       map[string]PfMTU
+}
+
+// Open locks the allocator of dataDir, blocking while another plugin instance
+// holds it, and loads its reservations. Close must be called to unlock it.
+func Open(dataDir string) (*Allocator, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the sriov data directory(%q): %v", dataDir, err)
+	}
+
+	lockPath := filepath.Join(dataDir, lockFileName)
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the allocator lock %q: %v", lockPath, err)
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock the allocator lock %q: %v", lockPath, err)
+	}
+
+	a := &Allocator{
+		dataDir:      dataDir,
+		lock:         lock,
+		reservations: make(map[string]Reservation),
+		vlanLeases:   make(map[string]map[int]Reservation),
+		pfMTUs:       make(map[string]PfMTU),
+	}
+	if err = a.load(reservationFileName, &a.reservations); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err = a.load(vlanLeaseFileName, &a.vlanLeases); err != nil {
+		a.Close()
+		return nil, err
+	}
+	if err = a.load(pfMTUFileName, &a.pfMTUs); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Close unlocks the allocator
+func (a *Allocator) Close() error {
+	// closing the lock file releases the flock
+	return a.lock.Close()
+}
+
+func (a *Allocator) load(fileName string, v interface{}) error {
+	path := filepath.Join(a.dataDir, fileName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read reservations %q: %v", path, err)
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse reservations %q: %v", path, err)
+	}
+	return nil
+}
+
+func (a *Allocator) save(fileName string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize reservations: %v", err)
+	}
+
+	path := filepath.Join(a.dataDir, fileName)
+	if err = utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write reservations %q: %v", path, err)
+	}
+	return nil
+}
+
+// Owner returns the reservation of the VF pciAddr, if any
+func (a *Allocator) Owner(pciAddr string) (Reservation, bool) {
+	r, ok := a.reservations[pciAddr]
+	return r, ok
+}
+
+// Reserve records the VF pciAddr as owned by the attachment ifName of
+// container cid. Reserving a VF again for its owner is not an error.
+func (a *Allocator) Reserve(pciAddr, cid, ifName string) error {
+	r := Reservation{ContainerID: cid, IfName: ifName}
+	if owner, ok := a.reservations[pciAddr]; ok {
+		if owner == r {
+			return nil
+		}
+		return utils.NewError(utils.ErrDeviceBusy, "", "VF %q is in use by container %q ifname %q", pciAddr, owner.ContainerID, owner.IfName)
+	}
+
+	a.reservations[pciAddr] = r
+	return a.save(reservationFileName, a.reservations)
+}
+
+// LeaseVlan leases one of vlans, the pool of the network network, not leased
+// to another attachment of the network to the attachment ifName of container
+// cid, the VLAN already leased to the attachment is returned again
+func (a *Allocator) LeaseVlan(network string, vlans []int, cid, ifName string) (int, error) {
+	r := Reservation{ContainerID: cid, IfName: ifName}
+	leases := a.vlanLeases[network]
+	for _, vlan := range vlans {
+		if owner, ok := leases[vlan]; ok && owner == r {
+			return vlan, nil
+		}
+	}
+
+	for _, vlan := range vlans {
+		if _, ok := leases[vlan]; ok {
+			continue
+		}
+		if leases == nil {
+			leases = make(map[int]Reservation)
+			a.vlanLeases[network] = leases
+		}
+		leases[vlan] = r
+		if err := a.save(vlanLeaseFileName, a.vlanLeases); err != nil {
+			return 0, err
+		}
+		return vlan, nil
+	}
+
+	return 0, utils.NewError(utils.ErrVlanPoolExhausted, "", "all the %d vlans of the pool of network %q are leased", len(vlans), network)
+}
+
+// VlanOwner returns the lease of the pool VLAN vlan of the network network,
+// if any
+func (a *Allocator) VlanOwner(network string, vlan int) (Reservation, bool) {
+	r, ok := a.vlanLeases[network][vlan]
+	return r, ok
+}
+
+// Release drops the VF reservations and the VLAN lease of the attachment
+// ifName of container cid
+func (a *Allocator) Release(cid, ifName string) error {
+	r := Reservation{ContainerID: cid, IfName: ifName}
+	released := false
+	for pciAddr, owner := range a.reservations {
+		if owner == r {
+			delete(a.reservations, pciAddr)
+			released = true
+		}
+	}
+	if released {
+		if err := a.save(reservationFileName, a.reservations); err != nil {
+			return err
+		}
+	}
+
+	released = false
+	for network, leases := range a.vlanLeases {
+		for vlan, owner := range leases {
+			if owner == r {
+				delete(leases, vlan)
+				released = true
+			}
+		}
+		if len(leases) == 0 {
+			delete(a.vlanLeases, network)
+		}
+	}
+	if released {
+		return a.save(vlanLeaseFileName, a.vlanLeases)
+	}
+	return nil
+}
+
+// RaisePfMtu records that the MTU of the PF pfName was raised from orig to
+// raised. The MTU recorded before the first raise is kept when the PF is
+// raised again.
+func (a *Allocator) RaisePfMtu(pfName string, orig, raised int) error {
+	m, ok := a.pfMTUs[pfName]
+	if !ok {
+		m.Orig = orig
+	}
+	m.Raised = raised
+	a.pfMTUs[pfName] = m
+	return a.save(pfMTUFileName, a.pfMTUs)
+}
+
+// PfMtu returns the MTUs recorded for the PF pfName, if it was raised
+func (a *Allocator) PfMtu(pfName string) (PfMTU, bool) {
+	m, ok := a.pfMTUs[pfName]
+	return m, ok
+}
+
+// DropPfMtu forgets the MTUs recorded for the PF pfName
+func (a *Allocator) DropPfMtu(pfName string) error {
+	if _, ok := a.pfMTUs[pfName]; !ok {
+		return nil
+	}
+	delete(a.pfMTUs, pfName)
+	return a.save(pfMTUFileName, a.pfMTUs)
+}
@@ -0,0 +1,127 @@
+package allocator
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Allocator", func() {
+	Context("Checking Reserve function", func() {
+		It("Assuming free VF", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.Reserve("0000:af:06.0", "cid1", "net1")).To(Succeed(), "Reserving a free VF should not cause an error")
+			Expect(a.Reserve("0000:af:06.0", "cid1", "net1")).To(Succeed(), "Reserving a VF again for its owner should not cause an error")
+		})
+		It("Assuming VF reserved by another attachment", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.Reserve("0000:af:06.0", "cid2", "net1")).NotTo(Succeed(), "Reserving a VF owned by another attachment should cause an error")
+		})
+	})
+	Context("Checking Owner function", func() {
+		It("Assuming reservation saved by an earlier Open", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			owner, ok := a.Owner("0000:af:06.0")
+			Expect(ok).To(BeTrue())
+			Expect(owner).To(Equal(Reservation{ContainerID: "cid1", IfName: "net1"}))
+		})
+		It("Assuming free VF", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			_, ok := a.Owner("0000:af:06.1")
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("Checking Release function", func() {
+		It("Assuming reserved VF", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(a.Release("cid1", "net1")).To(Succeed())
+			a.Close()
+
+			a, err = Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			_, ok := a.Owner("0000:af:06.0")
+			Expect(ok).To(BeFalse(), "Released VF should not be reserved")
+		})
+		It("Assuming attachment without reservation", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.Release("cid3", "net1")).To(Succeed())
+		})
+	})
+	Context("Checking LeaseVlan function", func() {
+		vlans := []int{100, 101}
+		It("Assuming free vlans", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.LeaseVlan("net-a", vlans, "cid1", "net1")).To(Equal(100))
+			Expect(a.LeaseVlan("net-a", vlans, "cid2", "net1")).To(Equal(101))
+		})
+		It("Assuming vlan leased by an earlier Open", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.LeaseVlan("net-a", vlans, "cid2", "net1")).To(Equal(101), "Leasing again should return the vlan of the attachment")
+			_, err = a.LeaseVlan("net-a", vlans, "cid3", "net1")
+			Expect(err).To(HaveOccurred(), "Leasing from an exhausted pool should cause an error")
+		})
+		It("Assuming released lease", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.Release("cid1", "net1")).To(Succeed())
+			Expect(a.LeaseVlan("net-a", vlans, "cid3", "net1")).To(Equal(100), "Released vlan should be leased again")
+			Expect(a.Release("cid2", "net1")).To(Succeed())
+			Expect(a.Release("cid3", "net1")).To(Succeed())
+		})
+		It("Assuming overlapping pools of two networks", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.LeaseVlan("net-a", vlans, "cid1", "net1")).To(Equal(100))
+			Expect(a.LeaseVlan("net-b", vlans, "cid2", "net1")).To(Equal(100), "The pool of another network should not be used up")
+			owner, ok := a.VlanOwner("net-b", 100)
+			Expect(ok).To(BeTrue())
+			Expect(owner).To(Equal(Reservation{ContainerID: "cid2", IfName: "net1"}))
+			Expect(a.Release("cid1", "net1")).To(Succeed())
+			Expect(a.Release("cid2", "net1")).To(Succeed())
+			_, ok = a.VlanOwner("net-b", 100)
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("Checking RaisePfMtu function", func() {
+		It("Assuming PF raised twice", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(a.RaisePfMtu("enp175s0f0", 1500, 9000)).To(Succeed())
+			Expect(a.RaisePfMtu("enp175s0f0", 9000, 9216)).To(Succeed())
+			a.Close()
+
+			a, err = Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			m, ok := a.PfMtu("enp175s0f0")
+			Expect(ok).To(BeTrue())
+			Expect(m).To(Equal(PfMTU{Orig: 1500, Raised: 9216}), "The MTU before the first raise should be kept")
+		})
+		It("Assuming dropped PF", func() {
+			a, err := Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer a.Close()
+			Expect(a.DropPfMtu("enp175s0f0")).To(Succeed())
+			_, ok := a.PfMtu("enp175s0f0")
+			Expect(ok).To(BeFalse())
+			Expect(a.DropPfMtu("enp175s0f0")).To(Succeed(), "Dropping a PF not raised should not cause an error")
+		})
+	})
+})
@@ -3,10 +3,14 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 
+	"github.com/containernetworking/cni/pkg/types"
 	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/allocator"
+	"github.com/intel/sriov-cni/pkg/devicedb"
 	"github.com/intel/sriov-cni/pkg/dpdk"
 	sriovtypes "github.com/intel/sriov-cni/pkg/types"
 	"github.com/intel/sriov-cni/pkg/utils"
@@ -36,67 +40,287 @@ var (
 	defaultCNIDir = "/var/lib/cni/sriov"
 	// MaxSharedVf defines maximum number of PFs a VF is being shared
 	MaxSharedVf = 2
+	// BondModes maps bond mode names to their kernel values
+	BondModes = map[string]int{
+		"balance-rr":    0,
+		"active-backup": 1,
+		"balance-xor":   2,
+		"broadcast":     3,
+		"802.3ad":       4,
+		"balance-tlb":   5,
+		"balance-alb":   6,
+	}
+	defaultBondMode   = "active-backup"
+	defaultBondMiimon = 100
+)
+
+// Host netdev name policies of hostNamePolicy
+const (
+	// HostNamePolicyOriginal gives the VF netdev back its name found at ADD
+	HostNamePolicyOriginal = "original"
+	// HostNamePolicyIndex names the VF netdev dev<ifindex>
+	HostNamePolicyIndex = "index"
 )
 
+// decodeConf unmarshals the netconf bytes into n. The fields which validate
+// themselves while decoding, such as vlans, report a *types.Error of their own.
+func decodeConf(bytes []byte, n *sriovtypes.NetConf) error {
+	err := json.Unmarshal(bytes, n)
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*types.Error); ok {
+		return e
+	}
+	return utils.NewConfError(utils.ErrDecodingFailure, "failed to load netconf: %v", err)
+}
+
 // LoadConf parses and validates stdin netconf and returns NetConf object
 func LoadConf(bytes []byte) (*sriovtypes.NetConf, []*sriovtypes.NetConf, error) {
 	n := &sriovtypes.NetConf{}
 	bondedNetConfList := make([]*sriovtypes.NetConf, 0)
-	if err := json.Unmarshal(bytes, n); err != nil {
-		return nil, nil, fmt.Errorf("failed to load netconf: %v", err)
+	if err := decodeConf(bytes, n); err != nil {
+		return nil, nil, err
 	}
 	logging.Debugf("PKKK-TEST LoadConf incoming netConf %+v", n)
 
-	// DeviceID takes precedence; if we are given a VF pciaddr then work from there
+	if n.RuntimeConfig.Mac != "" {
+		n.MAC = n.RuntimeConfig.Mac
+	}
+
+	defaultVlanSelection(n, bytes)
+
+	if err := ValidateConf(n); err != nil {
+		return nil, nil, err
+	}
+
+	if n.CNIDir == "" {
+		n.CNIDir = defaultCNIDir
+	}
+	if n.HostNamePolicy == "" {
+		n.HostNamePolicy = HostNamePolicyOriginal
+	}
+	if n.DeviceDB == "" {
+		n.DeviceDB = devicedb.DefaultFile
+	}
+	if err := devicedb.Load(n.DeviceDB); err != nil {
+		return nil, nil, utils.NewConfError(utils.ErrInvalidNetworkConfig, "%v", err)
+	}
+
+	if n.Bond != nil {
+		if err := loadBondConf(n); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// legacy form, several VF pciaddrs joined with '-'
 	if n.DeviceID != "" {
-		// Get rest of the VF information
-		//vfInfo, err := getVfInfo(n.DeviceID)
-
-		bondedDeviceIDList := strings.Split(n.DeviceID, "-")
-		for _, deviceID := range bondedDeviceIDList {
-			n1 := &sriovtypes.NetConf{}
-			*n1 = *n
-			n1.DeviceID = deviceID
-			if n.DPDKConf != nil {
-				n1.DPDKConf = &dpdk.Conf{}
-				*n1.DPDKConf = *n.DPDKConf
-			}
-			vfInfo, err := getVfInfo(deviceID)
+		for _, deviceID := range strings.Split(n.DeviceID, "-") {
+			n.Devices = append(n.Devices, sriovtypes.DeviceConf{DeviceID: deviceID})
+		}
+	}
+
+	// Devices take precedence; if we are given VF pciaddrs then work from there
+	if len(n.Devices) > 0 {
+		for _, dev := range n.Devices {
+			n1, err := loadDeviceConf(n, &dev)
 			if err != nil {
-				//return nil, err
-				logging.Debugf("PKKK-X getVfIndo error  deviceID %s", deviceID)
-			}
-			n1.DeviceInfo = vfInfo
-			n1.Master = vfInfo.Pfname
-			if n1.DPDKConf != nil {
-				n1.DPDKMode = true
-			}
-			if n1.CNIDir == "" {
-				n1.CNIDir = defaultCNIDir
+				return nil, nil, err
 			}
 			bondedNetConfList = append(bondedNetConfList, n1)
 		}
+		if err := validateDevices(n, bondedNetConfList); err != nil {
+			return nil, nil, err
+		}
 		for i, nc := range bondedNetConfList {
 			logging.Debugf("PKKK-X LoadConf index %d netconf %+v", i, nc)
 		}
 
 		return n, bondedNetConfList, nil
-	} else if n.Master == "" {
-		return nil, nil, fmt.Errorf("error: SRIOV-CNI loadConf: VF pci addr OR Master name is required")
 	}
 
-	if n.CNIDir == "" {
-		n.CNIDir = defaultCNIDir
+	if err := validateLinkTxRate(n); err != nil {
+		return nil, nil, err
 	}
 
 	if n.DPDKConf != nil {
-		// TO-DO: Validate Ddpdk conf here
 		n.DPDKMode = true
 	}
 
 	return n, nil, nil
 }
 
+// ParseConf parses stdin netconf without resolving the VFs in sysfs, for
+// commands which must not depend on the current state of the host
+func ParseConf(bytes []byte) (*sriovtypes.NetConf, error) {
+	n := &sriovtypes.NetConf{}
+	if err := decodeConf(bytes, n); err != nil {
+		return nil, err
+	}
+
+	defaultVlanSelection(n, bytes)
+
+	if n.CNIDir == "" {
+		n.CNIDir = defaultCNIDir
+	}
+	if n.DeviceDB == "" {
+		n.DeviceDB = devicedb.DefaultFile
+	}
+	// a broken device database must not prevent releasing the VFs, the
+	// built-in entries are used instead
+	if err := devicedb.Load(n.DeviceDB); err != nil {
+		logging.Debugf("ParseConf: %v", err)
+	}
+	if n.DPDKConf != nil {
+		n.DPDKMode = true
+	}
+
+	return n, nil
+}
+
+// loadDeviceConf returns the NetConf of the VF dev, built from the top level
+// NetConf n and the options overridden by dev
+func loadDeviceConf(n *sriovtypes.NetConf, dev *sriovtypes.DeviceConf) (*sriovtypes.NetConf, error) {
+	n1 := &sriovtypes.NetConf{}
+	*n1 = *n
+	n1.Devices = nil
+	n1.DeviceID = dev.DeviceID
+	n1.PodIfName = dev.IfName
+
+	if dev.Vlan != nil {
+		n1.Vlan = *dev.Vlan
+	}
+	if dev.MAC != "" {
+		n1.MAC = dev.MAC
+	}
+	if dev.MinTxRate != nil {
+		n1.MinTxRate = *dev.MinTxRate
+	}
+	if dev.MaxTxRate != nil {
+		n1.MaxTxRate = *dev.MaxTxRate
+	}
+	if err := validateTxRate(n1.MinTxRate, n1.MaxTxRate); err != nil {
+		return nil, err
+	}
+
+	dc := n.DPDKConf
+	if dev.DPDKConf != nil {
+		dc = dev.DPDKConf
+	}
+	if dc != nil {
+		n1.DPDKConf = &dpdk.Conf{}
+		*n1.DPDKConf = *dc
+		n1.DPDKMode = true
+	}
+
+	vfInfo, err := getVfInfo(dev.DeviceID)
+	if err != nil {
+		logging.Debugf("PKKK-X getVfIndo error  deviceID %s", dev.DeviceID)
+		return nil, utils.NewConfError(utils.ErrDeviceNotFound, "failed to get VF information for %q: %v", dev.DeviceID, err)
+	}
+	n1.DeviceInfo = vfInfo
+	n1.Master = vfInfo.Pfname
+
+	if err = validateLinkTxRate(n1); err != nil {
+		return nil, err
+	}
+
+	return n1, nil
+}
+
+// validateDevices checks that the VFs of a multi-device configuration are
+// distinct and sit on different PFs
+func validateDevices(n *sriovtypes.NetConf, devices []*sriovtypes.NetConf) error {
+	pciAddrs := make(map[string]bool)
+	pfs := make(map[string]string)
+	ifNames := make(map[string]bool)
+	macs := make(map[string]bool)
+
+	for _, d := range devices {
+		if pciAddrs[d.DeviceInfo.PCIaddr] {
+			return utils.NewConfError(utils.ErrConflictingOptions, "device %q is given more than once", d.DeviceInfo.PCIaddr)
+		}
+		pciAddrs[d.DeviceInfo.PCIaddr] = true
+
+		if other, ok := pfs[d.DeviceInfo.Pfname]; ok {
+			return utils.NewConfError(utils.ErrConflictingOptions, "devices %q and %q sit on the same PF %q", other, d.DeviceInfo.PCIaddr, d.DeviceInfo.Pfname)
+		}
+		pfs[d.DeviceInfo.Pfname] = d.DeviceInfo.PCIaddr
+
+		if d.PodIfName != "" {
+			if ifNames[d.PodIfName] {
+				return utils.NewConfError(utils.ErrConflictingOptions, "ifname %q is given more than once", d.PodIfName)
+			}
+			ifNames[d.PodIfName] = true
+		}
+
+		// the bond takes care of the MACs of its slaves
+		if d.MAC != "" && n.Bond == nil {
+			mac, _ := net.ParseMAC(d.MAC)
+			if macs[mac.String()] {
+				return utils.NewConfError(utils.ErrConflictingOptions, "mac %q is given to more than one device", d.MAC)
+			}
+			macs[mac.String()] = true
+		}
+
+		if n.Bond != nil && d.DPDKMode {
+			return utils.NewConfError(utils.ErrConflictingOptions, "bond is not supported in DPDK mode")
+		}
+	}
+
+	return nil
+}
+
+// loadBondConf sets the bond defaults and validates the bond options
+func loadBondConf(n *sriovtypes.NetConf) error {
+	b := n.Bond
+	if n.DeviceID == "" && len(n.Devices) == 0 {
+		return utils.NewConfError(utils.ErrInvalidBondConf, "bond requires the VFs to be given in deviceID or devices")
+	}
+	if n.DPDKConf != nil {
+		return utils.NewConfError(utils.ErrConflictingOptions, "bond is not supported in DPDK mode")
+	}
+
+	if b.Mode == "" {
+		b.Mode = defaultBondMode
+	}
+	if _, ok := BondModes[b.Mode]; !ok {
+		return utils.NewConfError(utils.ErrInvalidBondConf, "invalid bond mode %q", b.Mode)
+	}
+
+	// miimon 0 disables link monitoring, only an absent miimon is defaulted
+	if b.Miimon == nil {
+		miimon := defaultBondMiimon
+		b.Miimon = &miimon
+	}
+	if *b.Miimon < 0 {
+		return utils.NewConfError(utils.ErrInvalidBondConf, "invalid bond miimon %d", *b.Miimon)
+	}
+
+	if b.XmitHashPolicy != "" {
+		if _, ok := netlink.StringToBondXmitHashPolicyMap[b.XmitHashPolicy]; !ok {
+			return utils.NewConfError(utils.ErrInvalidBondConf, "invalid bond xmit_hash_policy %q", b.XmitHashPolicy)
+		}
+	}
+
+	if b.LacpRate != "" {
+		if _, ok := netlink.StringToBondLacpRateMap[b.LacpRate]; !ok {
+			return utils.NewConfError(utils.ErrInvalidBondConf, "invalid bond lacp_rate %q", b.LacpRate)
+		}
+		if b.Mode != "802.3ad" {
+			return utils.NewConfError(utils.ErrInvalidBondConf, "bond lacp_rate requires 802.3ad mode")
+		}
+	}
+
+	switch b.FailOverMac {
+	case "", "none", "active", "follow":
+	default:
+		return utils.NewConfError(utils.ErrInvalidBondConf, "invalid bond fail_over_mac %q", b.FailOverMac)
+	}
+
+	return nil
+}
+
 func getVfInfo(vfPci string) (*sriovtypes.VfInformation, error) {
 	pf, err := utils.GetPfName(vfPci)
 	if err != nil {
@@ -114,11 +338,9 @@ func getVfInfo(vfPci string) (*sriovtypes.VfInformation, error) {
 	}, nil
 }
 
-// AssignFreeVF takes in a NetConf object and updates it with an self allocated VF information
-func AssignFreeVF(conf *sriovtypes.NetConf) error {
-	var vfIdx int
-	var infos []string
-	var pciAddr string
+// AssignFreeVF takes in a NetConf object and updates it with a VF of its
+// master which is neither reserved in alloc nor in use
+func AssignFreeVF(conf *sriovtypes.NetConf, alloc *allocator.Allocator) error {
 	pfName := conf.Master
 
 	_, err := nLink.LinkByName(pfName)
@@ -138,47 +360,47 @@ func AssignFreeVF(conf *sriovtypes.NetConf) error {
 
 	// Select a free VF
 	for vf := 0; vf < vfTotal; vf++ {
-		infos, err = utils.GetVFLinkNames(pfName, vf)
+		pciAddr, err := utils.GetPciAddress(pfName, vf)
 		if err != nil {
-			if _, ok := err.(*os.PathError); ok {
-				continue
-			} else {
+			return fmt.Errorf("err in getting pci address for VF %d of PF %s: %q", vf, pfName, err)
+		}
+		if _, reserved := alloc.Owner(pciAddr); reserved {
+			continue
+		}
+
+		sharedvf := false
+		infos, err := utils.GetVFLinkNames(pfName, vf)
+		if err != nil {
+			if _, ok := err.(*os.PathError); !ok {
 				return fmt.Errorf("failed to read the virtfn%d dir of the device %q: %v", vf, pfName, err)
 			}
-
-		} else if len(infos) > 0 {
-
-			if len(infos) == MaxSharedVf {
-				conf.Sharedvf = true
+			// VFs bound to a userspace driver have no netdev, they can be
+			// handed to DPDK pods as they are
+			if !conf.DPDKMode {
+				continue
 			}
-
-			if len(infos) <= MaxSharedVf {
-				vfIdx = vf
-				pciAddr, err = utils.GetPciAddress(pfName, vfIdx)
-				if err != nil {
-					return fmt.Errorf("err in getting pci address for VF %d of PF %s: %q", vf, pfName, err)
-				}
-				break
-			} else {
-				return fmt.Errorf("multiple network devices found with VF id: %d under PF %s: %+v", vf, pfName, infos)
+			if driver, err := utils.GetDriverName(pciAddr); err != nil || !utils.IsUserspaceDriver(driver) {
+				continue
 			}
+		} else if len(infos) == 0 {
+			continue
+		} else if len(infos) > MaxSharedVf {
+			return fmt.Errorf("multiple network devices found with VF id: %d under PF %s: %+v", vf, pfName, infos)
+		} else if len(infos) == MaxSharedVf {
+			sharedvf = true
 		}
-	}
 
-	if len(infos) == 0 {
-		return fmt.Errorf("no virtual network resources available for the %q", conf.Master)
-	}
-
-	// instantiate DeviceInfo
-	if pciAddr != "" {
-		vfInfo := &sriovtypes.VfInformation{
+		// instantiate DeviceInfo
+		conf.Sharedvf = sharedvf
+		conf.DeviceInfo = &sriovtypes.VfInformation{
 			PCIaddr: pciAddr,
 			Pfname:  pfName,
-			Vfid:    vfIdx,
+			Vfid:    vf,
 		}
-		conf.DeviceInfo = vfInfo
+		return nil
 	}
-	return nil
+
+	return fmt.Errorf("no virtual network resources available for the %q", conf.Master)
 }
 
 func init() {
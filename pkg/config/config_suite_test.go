@@ -1,6 +1,8 @@
 package config
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/intel/sriov-cni/pkg/utils"
@@ -18,14 +20,20 @@ func TestConfig(t *testing.T) {
 	RunSpecs(t, "Config Suite")
 }
 
+var dataDir string
+
 var _ = BeforeSuite(func() {
 	// create test sys tree
 	err := utils.CreateTmpSysFs()
 	check(err)
+	dataDir, err = ioutil.TempDir("/tmp", "sriovplugin-testfiles-")
+	check(err)
 })
 
 var _ = AfterSuite(func() {
 	var err error
 	err = utils.RemoveTmpSysFs()
 	check(err)
+	err = os.RemoveAll(dataDir)
+	check(err)
 })
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/allocator"
 	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
@@ -63,8 +66,9 @@ var _ = Describe("Config", func() {
 	    "gateway": "10.55.206.1"
 	}
 			}`)
-			_, err := LoadConf(conf)
+			n, _, err := LoadConf(conf)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(n.HostNamePolicy).To(Equal(HostNamePolicyOriginal))
 		})
 		It("Assuming correct config file - existing DeviceID", func() {
 			conf := []byte(`{
@@ -83,9 +87,97 @@ var _ = Describe("Config", func() {
             "gateway": "10.55.206.1"
         }
                         }`)
-			_, err := LoadConf(conf)
+			_, _, err := LoadConf(conf)
 			Expect(err).NotTo(HaveOccurred())
 		})
+		It("Assuming vlan range", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.1",
+        "vlans": [10, "100-199"]
+                        }`)
+			n, _, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Vlans).To(HaveLen(101))
+			Expect(n.Vlans[100]).To(Equal(199))
+			Expect(n.VlanSelection).To(Equal(VlanSelectionPool), "VLAN ranges should be leased from the pool")
+		})
+		It("Assuming vlan range out of the VLAN ID range", func() {
+			_, _, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": ["0-2147483647"]
+                        }`))
+			Expect(err).To(HaveOccurred(), "Out of range vlan range should not be expanded")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming reversed vlan range", func() {
+			_, _, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": ["200-100"]
+                        }`))
+			Expect(err).To(HaveOccurred(), "Reversed vlan range should cause an error")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming vlan range starting at the untagged vlan", func() {
+			_, _, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": ["0-10"]
+                        }`))
+			Expect(err).To(HaveOccurred(), "VLAN 0 should not be leased from a pool")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming untagged vlan in the pool", func() {
+			_, _, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": [0, 100],
+        "vlanSelection": "pool"
+                        }`))
+			Expect(err).To(HaveOccurred(), "VLAN 0 should not be leased from a pool")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming vlan list", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.1",
+        "vlans": [100, 101]
+                        }`)
+			n, _, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.VlanSelection).To(Equal(VlanSelectionOrdinal))
+		})
+		It("Assuming tx rate within the PF link speed", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.1",
+        "min_tx_rate": 1000,
+        "max_tx_rate": 10000
+                        }`)
+			n, _, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.MaxTxRate).To(Equal(10000))
+		})
+		It("Assuming tx rate above the PF link speed", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.1",
+        "max_tx_rate": 40000
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
 		It("Assuming incorrect config file - not existing DeviceID", func() {
 			conf := []byte(`{
         "name": "mynet",
@@ -103,7 +195,7 @@ var _ = Describe("Config", func() {
             "gateway": "10.55.206.1"
         }
                         }`)
-			_, err := LoadConf(conf)
+			_, _, err := LoadConf(conf)
 			Expect(err).To(HaveOccurred())
 		})
 		It("Assuming incorrect config file - broken json", func() {
@@ -122,7 +214,7 @@ var _ = Describe("Config", func() {
             "gateway": "10.55.206.1"
         }
                         }`)
-			_, err := LoadConf(conf)
+			_, _, err := LoadConf(conf)
 			Expect(err).To(HaveOccurred())
 		})
 		It("Assuming incorrect config file - missing master", func() {
@@ -140,10 +232,494 @@ var _ = Describe("Config", func() {
             "gateway": "10.55.206.1"
         }
                         }`)
-			_, err := LoadConf(conf)
+			_, _, err := LoadConf(conf)
 			Expect(err).Should(MatchError("error: SRIOV-CNI loadConf: VF pci addr OR Master name is required"))
 		})
 	})
+	Context("Checking LoadConf function with bond", func() {
+		It("Assuming correct bond config", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "mode": "802.3ad",
+            "xmit_hash_policy": "layer3+4",
+            "lacp_rate": "fast"
+        }
+                        }`)
+			n, bondedlist, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bondedlist).To(HaveLen(2))
+			Expect(*n.Bond.Miimon).To(Equal(100))
+		})
+		It("Assuming bond with link monitoring disabled", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "mode": "active-backup",
+            "miimon": 0
+        }
+                        }`)
+			n, _, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*n.Bond.Miimon).To(Equal(0), "miimon 0 should not be defaulted")
+		})
+		It("Assuming negative bond miimon", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "miimon": -1
+        }
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming bond without mode defaults to active-backup", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {}
+                        }`)
+			n, _, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Bond.Mode).To(Equal("active-backup"))
+		})
+		It("Assuming incorrect bond mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "mode": "round-robin"
+        }
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming lacp_rate without 802.3ad mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "mode": "active-backup",
+            "lacp_rate": "fast"
+        }
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming bond in DPDK mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:02.0",
+        "bond": {
+            "mode": "active-backup"
+        },
+        "dpdk": {
+            "kernel_driver": "i40evf",
+            "dpdk_driver": "igb_uio",
+            "dpdk_tool": "/opt/dpdk/usertools/dpdk-devbind.py"
+        }
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).Should(MatchError("error: SRIOV-CNI loadConf: bond is not supported in DPDK mode"))
+		})
+	})
+	Context("Checking LoadConf function with devices", func() {
+		It("Assuming correct devices config with overrides", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "vlan": 100,
+        "devices": [
+            { "deviceID": "0000:af:06.0", "vlan": 0, "ifname": "net1" },
+            { "deviceID": "0000:af:02.0", "mac": "66:77:88:99:aa:bb",
+              "dpdk": {
+                  "kernel_driver": "i40evf",
+                  "dpdk_driver": "igb_uio",
+                  "dpdk_tool": "/opt/dpdk/usertools/dpdk-devbind.py"
+              }
+            }
+        ]
+                        }`)
+			_, devices, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices).To(HaveLen(2))
+			Expect(devices[0].Vlan).To(Equal(0))
+			Expect(devices[0].PodIfName).To(Equal("net1"))
+			Expect(devices[0].DPDKMode).To(BeFalse())
+			Expect(devices[0].Master).To(Equal("enp175s0f1"))
+			Expect(devices[1].Vlan).To(Equal(100))
+			Expect(devices[1].MAC).To(Equal("66:77:88:99:aa:bb"))
+			Expect(devices[1].DPDKMode).To(BeTrue())
+			Expect(devices[1].Master).To(Equal("enp175s0f0"))
+		})
+		It("Assuming deviceID and devices both given", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0",
+        "devices": [
+            { "deviceID": "0000:af:02.0" }
+        ]
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).Should(MatchError("error: SRIOV-CNI loadConf: deviceID and devices are mutually exclusive"))
+		})
+		It("Assuming not existing device", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0" },
+            { "deviceID": "0000:af:06.3" }
+        ]
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming duplicated device", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0" },
+            { "deviceID": "0000:af:06.0" }
+        ]
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming the same mac for several devices", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "mac": "66:77:88:99:aa:bb",
+        "deviceID": "0000:af:06.0-0000:af:02.0"
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred(), "Devices sharing a mac outside of a bond should cause an error")
+		})
+		It("Assuming mac given in runtimeConfig", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "mac": "66:77:88:99:aa:bb",
+        "deviceID": "0000:af:06.0",
+        "runtimeConfig": { "mac": "66:77:88:99:aa:cc" }
+                        }`)
+			_, devices, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(devices[0].MAC).To(Equal("66:77:88:99:aa:cc"), "runtimeConfig mac should override mac")
+		})
+		It("Assuming devices on the same PF", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-0000:af:06.1"
+                        }`)
+			_, _, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking ValidateConf function", func() {
+		validate := func(conf []byte) *types.Error {
+			var netconf sriovtypes.NetConf
+			check(json.Unmarshal(conf, &netconf))
+			err := ValidateConf(&netconf)
+			if err == nil {
+				return nil
+			}
+			return err.(*types.Error)
+		}
+		It("Assuming correct config", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0",
+        "vlan": 4094,
+        "mac": "66:77:88:99:aa:bb"
+                        }`))
+			Expect(err).To(BeNil())
+		})
+		It("Assuming vlan out of range", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlan": 4095
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+			Expect(err.Msg).To(Equal("error: SRIOV-CNI loadConf: vlan 4095 is out of the 0-4094 range"))
+		})
+		It("Assuming vlans out of range", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": [100, -1]
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming both vlan and vlans", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlan": 100,
+        "vlans": [100, 200]
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrConflictingOptions))
+		})
+		It("Assuming malformed deviceID", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:06.0-af:06.1"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidPCIAddress))
+		})
+		It("Assuming malformed device mac", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0", "mac": "66:77:88:99:aa" }
+        ]
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidMAC))
+		})
+		It("Assuming device without deviceID", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "vlan": 100 }
+        ]
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidNetworkConfig))
+		})
+		It("Assuming invalid spoofchk", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "spoofchk": "yes"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming invalid link_state", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "link_state": "up"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming valid VF settings", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "spoofchk": "off",
+        "trust": "on",
+        "link_state": "enable"
+                        }`))
+			Expect(err).To(BeNil())
+		})
+		It("Assuming unknown vlanSelection", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": [100, 101],
+        "vlanSelection": "podname"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming pool vlanSelection without vlans", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlanSelection": "pool"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming device vlan with vlanSelection", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0", "vlan": 200 },
+            { "deviceID": "0000:af:02.0" }
+        ],
+        "vlans": [100, 101],
+        "vlanSelection": "hash"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrConflictingOptions))
+		})
+		It("Assuming device vlan with vlans and the default vlanSelection", func() {
+			_, _, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0", "vlan": 200 }
+        ],
+        "vlans": ["100-110"]
+                        }`))
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrConflictingOptions))
+		})
+		It("Assuming vlanQoS out of range", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlan": 100,
+        "vlanQoS": 8
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming invalid vlanProto", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlan": 100,
+        "vlanProto": "802.1x"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming 802.1ad without vlan", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlanProto": "802.1ad"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVlan))
+		})
+		It("Assuming vlan with qos and 802.1ad", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlan": 100,
+        "vlanQoS": 5,
+        "vlanProto": "802.1ad"
+                        }`))
+			Expect(err).To(BeNil())
+		})
+		It("Assuming mtu out of range", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "mtu": 40
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming adjustPfMtu without mtu", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "adjustPfMtu": true
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming invalid hostNamePolicy", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "hostNamePolicy": "kernel"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidNetworkConfig))
+		})
+		It("Assuming min_tx_rate above max_tx_rate", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "min_tx_rate": 2000,
+        "max_tx_rate": 1000
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming negative device max_tx_rate", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "devices": [
+            { "deviceID": "0000:af:06.0", "max_tx_rate": -1 }
+        ]
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidVfSetting))
+		})
+		It("Assuming multicast mac", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "mac": "01:00:5e:00:00:01"
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidMAC))
+		})
+		It("Assuming incomplete dpdk config", func() {
+			err := validate([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "dpdk": {
+            "kernel_driver": "i40evf",
+            "dpdk_tool": "/opt/dpdk/usertools/dpdk-devbind.py"
+        }
+                        }`))
+			Expect(err.Code).To(Equal(utils.ErrInvalidDPDKConf))
+		})
+	})
+	Context("Checking ParseConf function", func() {
+		It("Assuming not existing VF", func() {
+			n, err := ParseConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "deviceID": "0000:af:07.0",
+        "dpdk": {
+            "kernel_driver": "i40evf",
+            "dpdk_driver": "vfio-pci",
+            "dpdk_tool": "/opt/dpdk/usertools/dpdk-devbind.py"
+        }
+                        }`))
+			Expect(err).NotTo(HaveOccurred(), "Parsing should not depend on sysfs")
+			Expect(n.CNIDir).To(Equal(defaultCNIDir))
+			Expect(n.DPDKMode).To(BeTrue())
+		})
+		It("Assuming malformed config", func() {
+			_, err := ParseConf([]byte(`{"name": "mynet"`))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming vlan range without vlanSelection", func() {
+			n, err := ParseConf([]byte(`{
+        "name": "mynet",
+        "type": "sriov",
+        "master": "enp175s0f1",
+        "vlans": ["100-110"]
+                        }`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.VlanSelection).To(Equal(VlanSelectionPool), "CHECK needs the selection mode ADD used")
+		})
+	})
 	Context("Checking getVfInfo function", func() {
 		It("Assuming existing PF", func() {
 			_, err := getVfInfo("0000:af:06.0")
@@ -177,8 +753,37 @@ var _ = Describe("Config", func() {
 			json.Unmarshal(conf, &netconf)
 			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			nLink = mocked
-			err := AssignFreeVF(&netconf)
+			alloc, err := allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			err = AssignFreeVF(&netconf, alloc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.DeviceInfo.PCIaddr).To(Equal("0000:af:06.0"))
+		})
+		It("Assuming first VF reserved", func() {
+			netconf := sriovtypes.NetConf{Master: "enp175s0f1"}
+			nLink = mocked
+			alloc, err := allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			Expect(alloc.Reserve("0000:af:06.0", "cid1", "net1")).To(Succeed())
+			defer alloc.Release("cid1", "net1")
+			err = AssignFreeVF(&netconf, alloc)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.DeviceInfo.PCIaddr).To(Equal("0000:af:06.1"), "Reserved VF should be skipped")
+		})
+		It("Assuming all VFs reserved", func() {
+			netconf := sriovtypes.NetConf{Master: "enp175s0f1"}
+			nLink = mocked
+			alloc, err := allocator.Open(dataDir)
 			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			Expect(alloc.Reserve("0000:af:06.0", "cid1", "net1")).To(Succeed())
+			Expect(alloc.Reserve("0000:af:06.1", "cid2", "net1")).To(Succeed())
+			defer alloc.Release("cid1", "net1")
+			defer alloc.Release("cid2", "net1")
+			err = AssignFreeVF(&netconf, alloc)
+			Expect(err).To(HaveOccurred(), "No free VF should cause an error")
 		})
 		It("Assuming not existing interface", func() {
 			conf := []byte(`{
@@ -200,8 +805,67 @@ var _ = Describe("Config", func() {
 			json.Unmarshal(conf, &netconf)
 			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(nil, fmt.Errorf("No such interface"))
 			nLink = mocked
-			err := AssignFreeVF(&netconf)
+			alloc, err := allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			err = AssignFreeVF(&netconf, alloc)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking SelectVlan function", func() {
+		vlans := []int{100, 101, 102}
+		It("Assuming explicit vlan in runtimeConfig", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionExplicit, Vlans: vlans, RuntimeConfig: sriovtypes.RuntimeConfig{Vlan: 101}}
+			Expect(SelectVlan(n, "cid1", "net1", "K8S_POD_NAME=web-abc12", nil)).To(Equal(101))
+		})
+		It("Assuming explicit vlan in CNI_ARGS", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionExplicit}
+			Expect(SelectVlan(n, "cid1", "net1", "IgnoreUnknown=1;VLAN=300", nil)).To(Equal(300))
+		})
+		It("Assuming explicit vlan not in vlans", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionExplicit, Vlans: vlans}
+			_, err := SelectVlan(n, "cid1", "net1", "VLAN=300", nil)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming explicit selection without vlan", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionExplicit}
+			_, err := SelectVlan(n, "cid1", "net1", "K8S_POD_NAME=web-0", nil)
 			Expect(err).To(HaveOccurred())
 		})
+		It("Assuming StatefulSet pod", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionOrdinal, Vlans: vlans}
+			Expect(SelectVlan(n, "cid1", "net1", "K8S_POD_NAMESPACE=default;K8S_POD_NAME=db-2", nil)).To(Equal(102))
+		})
+		It("Assuming Deployment pod with ordinal selection", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionOrdinal, Vlans: vlans}
+			_, err := SelectVlan(n, "cid1", "net1", "K8S_POD_NAME=web-5d8f7c-x2b9q", nil)
+			Expect(err).To(HaveOccurred(), "Pod name without ordinal should cause an error")
+		})
+		It("Assuming ordinal beyond vlans", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionOrdinal, Vlans: vlans}
+			_, err := SelectVlan(n, "cid1", "net1", "K8S_POD_NAME=db-3", nil)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming hashed selection", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionHash, Vlans: vlans}
+			args := "K8S_POD_NAMESPACE=default;K8S_POD_NAME=web-5d8f7c-x2b9q"
+			vlan, err := SelectVlan(n, "cid1", "net1", args, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vlans).To(ContainElement(vlan))
+			Expect(SelectVlan(n, "cid2", "net1", args, nil)).To(Equal(vlan), "Same pod should get the same vlan")
+		})
+		It("Assuming pool selection", func() {
+			n := &sriovtypes.NetConf{VlanSelection: VlanSelectionPool, Vlans: vlans[:2]}
+			alloc, err := allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			defer alloc.Release("cid1", "net1")
+			defer alloc.Release("cid2", "net1")
+			Expect(SelectVlan(n, "cid1", "net1", "", alloc)).To(Equal(100))
+			Expect(SelectVlan(n, "cid2", "net1", "", alloc)).To(Equal(101))
+			_, err = SelectVlan(n, "cid3", "net1", "", alloc)
+			Expect(err).To(HaveOccurred(), "Exhausted pool should cause an error")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrVlanPoolExhausted))
+		})
 	})
 })
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"net"
+	"strings"
+
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+const (
+	minVlanID  = 0
+	maxVlanID  = 4094
+	maxVlanQoS = 7
+	// a pool leases tagged VLANs, VLAN 0 would leave the VF untagged
+	minPoolVlanID = 1
+	// 68 is the minimum MTU of IPv4
+	minMTU = 68
+	maxMTU = 65535
+)
+
+// ValidateConf validates the NetConf parsed from stdin before the VFs are
+// resolved, it returns a *types.Error describing the first invalid option
+func ValidateConf(n *sriovtypes.NetConf) error {
+	if n.DeviceID == "" && len(n.Devices) == 0 && n.Master == "" {
+		return utils.NewConfError(utils.ErrInvalidNetworkConfig, "VF pci addr OR Master name is required")
+	}
+	if n.DeviceID != "" && len(n.Devices) > 0 {
+		return utils.NewConfError(utils.ErrConflictingOptions, "deviceID and devices are mutually exclusive")
+	}
+
+	if err := validateVlan(n.Vlan); err != nil {
+		return err
+	}
+	for _, vlan := range n.Vlans {
+		if err := validateVlan(vlan); err != nil {
+			return err
+		}
+	}
+	if n.Vlan != 0 && len(n.Vlans) > 0 {
+		return utils.NewConfError(utils.ErrConflictingOptions, "vlan and vlans are mutually exclusive")
+	}
+	if err := validateVlanSelection(n); err != nil {
+		return err
+	}
+	if err := validateVlanQoSProto(n); err != nil {
+		return err
+	}
+
+	if err := validateMAC(n.MAC); err != nil {
+		return err
+	}
+
+	if err := validateOnOff("spoofchk", n.SpoofChk); err != nil {
+		return err
+	}
+	if err := validateOnOff("trust", n.Trust); err != nil {
+		return err
+	}
+	if _, ok := utils.VfLinkStates[n.LinkState]; n.LinkState != "" && !ok {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "invalid link_state %q, expected auto, enable or disable", n.LinkState)
+	}
+
+	if err := validateTxRate(n.MinTxRate, n.MaxTxRate); err != nil {
+		return err
+	}
+	if n.MTU != 0 && (n.MTU < minMTU || n.MTU > maxMTU) {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "mtu %d is out of the %d-%d range", n.MTU, minMTU, maxMTU)
+	}
+	if n.AdjustPfMtu && n.MTU == 0 {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "adjustPfMtu requires mtu")
+	}
+
+	switch n.HostNamePolicy {
+	case "", HostNamePolicyOriginal, HostNamePolicyIndex:
+	default:
+		return utils.NewConfError(utils.ErrInvalidNetworkConfig, "invalid hostNamePolicy %q, expected %s or %s", n.HostNamePolicy, HostNamePolicyOriginal, HostNamePolicyIndex)
+	}
+
+	if n.DPDKConf != nil {
+		if err := dpdk.ValidateConf(n.DPDKConf); err != nil {
+			return err
+		}
+	}
+
+	if n.DeviceID != "" {
+		for _, deviceID := range strings.Split(n.DeviceID, "-") {
+			if err := validatePCIAddress(deviceID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dev := range n.Devices {
+		if err := validateDevice(&dev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateDevice(dev *sriovtypes.DeviceConf) error {
+	if dev.DeviceID == "" {
+		return utils.NewConfError(utils.ErrInvalidNetworkConfig, "deviceID is required for every device")
+	}
+	if err := validatePCIAddress(dev.DeviceID); err != nil {
+		return err
+	}
+	if dev.Vlan != nil {
+		if err := validateVlan(*dev.Vlan); err != nil {
+			return err
+		}
+	}
+	if err := validateMAC(dev.MAC); err != nil {
+		return err
+	}
+	minTxRate, maxTxRate := 0, 0
+	if dev.MinTxRate != nil {
+		minTxRate = *dev.MinTxRate
+	}
+	if dev.MaxTxRate != nil {
+		maxTxRate = *dev.MaxTxRate
+	}
+	if err := validateTxRate(minTxRate, maxTxRate); err != nil {
+		return err
+	}
+	if dev.DPDKConf != nil {
+		if err := dpdk.ValidateConf(dev.DPDKConf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateVlan(vlan int) error {
+	if vlan < minVlanID || vlan > maxVlanID {
+		return utils.NewConfError(utils.ErrInvalidVlan, "vlan %d is out of the %d-%d range", vlan, minVlanID, maxVlanID)
+	}
+	return nil
+}
+
+func validateMAC(mac string) error {
+	if mac == "" {
+		return nil
+	}
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil || len(hwaddr) != 6 {
+		return utils.NewConfError(utils.ErrInvalidMAC, "invalid mac %q", mac)
+	}
+	// a VF MAC must be a unicast address
+	if hwaddr[0]&0x01 != 0 || hwaddr.String() == "00:00:00:00:00:00" {
+		return utils.NewConfError(utils.ErrInvalidMAC, "mac %q is not a unicast address", mac)
+	}
+	return nil
+}
+
+func validateVlanSelection(n *sriovtypes.NetConf) error {
+	if n.VlanSelection == "" {
+		return nil
+	}
+	if !VlanSelections[n.VlanSelection] {
+		return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlanSelection %q, expected explicit, ordinal, hash or pool", n.VlanSelection)
+	}
+	if n.Vlan != 0 {
+		return utils.NewConfError(utils.ErrConflictingOptions, "vlan and vlanSelection are mutually exclusive")
+	}
+	// the selected VLAN is set on every VF of the attachment
+	for _, dev := range n.Devices {
+		if dev.Vlan != nil {
+			return utils.NewConfError(utils.ErrConflictingOptions, "vlan of device %q and vlanSelection are mutually exclusive", dev.DeviceID)
+		}
+	}
+	if n.VlanSelection != VlanSelectionExplicit && len(n.Vlans) == 0 {
+		return utils.NewConfError(utils.ErrInvalidVlan, "vlanSelection %s requires vlans", n.VlanSelection)
+	}
+	if n.VlanSelection == VlanSelectionPool {
+		for _, vlan := range n.Vlans {
+			if vlan < minPoolVlanID {
+				return utils.NewConfError(utils.ErrInvalidVlan, "pool vlan %d is out of the %d-%d range", vlan, minPoolVlanID, maxVlanID)
+			}
+		}
+	}
+	return nil
+}
+
+func validateVlanQoSProto(n *sriovtypes.NetConf) error {
+	if n.VlanQoS < 0 || n.VlanQoS > maxVlanQoS {
+		return utils.NewConfError(utils.ErrInvalidVlan, "vlanQoS %d is out of the 0-%d range", n.VlanQoS, maxVlanQoS)
+	}
+	if _, ok := utils.VlanProtos[n.VlanProto]; n.VlanProto != "" && !ok {
+		return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlanProto %q, expected %s or %s", n.VlanProto, utils.VlanProto8021Q, utils.VlanProto8021AD)
+	}
+
+	hasVlan := n.Vlan != 0 || len(n.Vlans) > 0 || n.VlanSelection == VlanSelectionExplicit
+	for _, dev := range n.Devices {
+		hasVlan = hasVlan || (dev.Vlan != nil && *dev.Vlan != 0)
+	}
+	if !hasVlan && (n.VlanQoS != 0 || n.VlanProto == utils.VlanProto8021AD) {
+		return utils.NewConfError(utils.ErrInvalidVlan, "vlanQoS and vlanProto %s require a vlan", utils.VlanProto8021AD)
+	}
+	return nil
+}
+
+func validateTxRate(minTxRate, maxTxRate int) error {
+	if minTxRate < 0 || maxTxRate < 0 {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "tx rates must not be negative")
+	}
+	if maxTxRate != 0 && minTxRate > maxTxRate {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "min_tx_rate %d is above max_tx_rate %d", minTxRate, maxTxRate)
+	}
+	return nil
+}
+
+// validateLinkTxRate checks the tx rates of n against the speed of its PF
+func validateLinkTxRate(n *sriovtypes.NetConf) error {
+	if n.MinTxRate == 0 && n.MaxTxRate == 0 {
+		return nil
+	}
+
+	speed, err := utils.GetLinkSpeed(n.Master)
+	if err != nil {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "failed to check the tx rates: %v", err)
+	}
+	// the speed is unknown while the PF has no carrier
+	if speed <= 0 {
+		return nil
+	}
+
+	if n.MinTxRate > speed || n.MaxTxRate > speed {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "tx rates %d-%d Mbps exceed the %d Mbps of PF %q", n.MinTxRate, n.MaxTxRate, speed, n.Master)
+	}
+	return nil
+}
+
+func validateOnOff(name, value string) error {
+	if value != "" && value != "on" && value != "off" {
+		return utils.NewConfError(utils.ErrInvalidVfSetting, "invalid %s %q, expected on or off", name, value)
+	}
+	return nil
+}
+
+func validatePCIAddress(addr string) error {
+	if !utils.IsValidPCIAddress(addr) {
+		return utils.NewConfError(utils.ErrInvalidPCIAddress, "invalid VF pci addr %q", addr)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/intel/sriov-cni/pkg/allocator"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+// VLAN selection modes of vlanSelection
+const (
+	// VlanSelectionExplicit takes the VLAN from runtimeConfig or the VLAN
+	// CNI_ARGS, it must be one of vlans when vlans is given
+	VlanSelectionExplicit = "explicit"
+	// VlanSelectionOrdinal takes the element of vlans indexed by the
+	// StatefulSet ordinal ending the pod name
+	VlanSelectionOrdinal = "ordinal"
+	// VlanSelectionHash takes the element of vlans picked by a hash of the
+	// pod namespace and name
+	VlanSelectionHash = "hash"
+	// VlanSelectionPool leases an element of vlans not used by another pod
+	// of the node on the same network
+	VlanSelectionPool = "pool"
+)
+
+// VlanSelections lists the supported VLAN selection modes
+var VlanSelections = map[string]bool{
+	VlanSelectionExplicit: true,
+	VlanSelectionOrdinal:  true,
+	VlanSelectionHash:     true,
+	VlanSelectionPool:     true,
+}
+
+// ParseCNIArgs returns the key/value pairs of CNI_ARGS, malformed pairs are
+// skipped
+func ParseCNIArgs(cniArgs string) map[string]string {
+	kvs := make(map[string]string)
+	for _, pair := range strings.Split(cniArgs, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		kvs[kv[0]] = kv[1]
+	}
+	return kvs
+}
+
+// defaultVlanSelection sets the vlanSelection mode of n, parsed from bytes,
+// when vlans is given without one. vlans used to be indexed by the pod name
+// ordinal, VLAN ranges are leased from the pool.
+func defaultVlanSelection(n *sriovtypes.NetConf, bytes []byte) {
+	if len(n.Vlans) > 0 && n.VlanSelection == "" {
+		n.VlanSelection = VlanSelectionOrdinal
+		if hasVlanRange(bytes) {
+			n.VlanSelection = VlanSelectionPool
+		}
+	}
+}
+
+// SelectVlan returns the VLAN picked by the vlanSelection mode of n for the
+// attachment ifName of container cid, 0 if n has no VLAN selection. alloc
+// holds the VLAN leases of the pool mode. Errors are *types.Error.
+func SelectVlan(n *sriovtypes.NetConf, cid, ifName, cniArgs string, alloc *allocator.Allocator) (int, error) {
+	kvs := ParseCNIArgs(cniArgs)
+	podName := kvs["K8S_POD_NAME"]
+
+	switch n.VlanSelection {
+	case "":
+		return 0, nil
+	case VlanSelectionExplicit:
+		vlan := n.RuntimeConfig.Vlan
+		if vlan == 0 && kvs["VLAN"] != "" {
+			var err error
+			if vlan, err = strconv.Atoi(kvs["VLAN"]); err != nil {
+				return 0, utils.NewError(utils.ErrInvalidVlan, "", "invalid VLAN %q in CNI_ARGS: %v", kvs["VLAN"], err)
+			}
+		}
+		if vlan == 0 {
+			return 0, utils.NewError(utils.ErrInvalidVlan, "", "no vlan given in runtimeConfig or CNI_ARGS")
+		}
+		if err := validateVlan(vlan); err != nil {
+			return 0, err
+		}
+		if len(n.Vlans) > 0 && !containsVlan(n.Vlans, vlan) {
+			return 0, utils.NewError(utils.ErrInvalidVlan, "", "vlan %d is not one of vlans", vlan)
+		}
+		return vlan, nil
+	case VlanSelectionOrdinal:
+		if podName == "" {
+			return 0, utils.NewError(utils.ErrInvalidVlan, "", "no K8S_POD_NAME in CNI_ARGS")
+		}
+		ordinal, err := strconv.Atoi(podName[strings.LastIndex(podName, "-")+1:])
+		if err != nil || ordinal < 0 {
+			return 0, utils.NewError(utils.ErrInvalidVlan, "", "pod name %q does not end with a StatefulSet ordinal", podName)
+		}
+		if ordinal >= len(n.Vlans) {
+			return 0, utils.NewError(utils.ErrInvalidVlan, "", "ordinal %d of pod %q is beyond the %d vlans", ordinal, podName, len(n.Vlans))
+		}
+		return n.Vlans[ordinal], nil
+	case VlanSelectionHash:
+		// pods without a name are spread by their container
+		key := cid
+		if podName != "" {
+			key = kvs["K8S_POD_NAMESPACE"] + "/" + podName
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return n.Vlans[h.Sum32()%uint32(len(n.Vlans))], nil
+	case VlanSelectionPool:
+		return alloc.LeaseVlan(n.Name, n.Vlans, cid, ifName)
+	}
+
+	return 0, utils.NewError(utils.ErrInvalidVlan, "", "unknown vlanSelection %q", n.VlanSelection)
+}
+
+// hasVlanRange reports whether vlans is given with "first-last" ranges in the
+// netconf bytes
+func hasVlanRange(bytes []byte) bool {
+	var raw struct {
+		Vlans []json.RawMessage `json:"vlans"`
+	}
+	if err := json.Unmarshal(bytes, &raw); err != nil {
+		return false
+	}
+	for _, item := range raw.Vlans {
+		if len(item) > 0 && item[0] == '"' {
+			return true
+		}
+	}
+	return false
+}
+
+func containsVlan(vlans []int, vlan int) bool {
+	for _, v := range vlans {
+		if v == vlan {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package devicedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/sriov-cni/pkg/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultFile is the node-local device database read when the netconf does
+// not name one
+const DefaultFile = "/etc/sriov-cni/devices.json"
+
+// Device describes the SR-IOV capabilities of a VF model, identified by its
+// PCI vendor and device IDs
+type Device struct {
+	Name   string `json:"name" yaml:"name"`
+	Vendor string `json:"vendor" yaml:"vendor"`
+	Device string `json:"device" yaml:"device"`
+	// Driver names the vfdriver implementation handling the VF, the
+	// generic one if empty
+	Driver string `json:"driver,omitempty" yaml:"driver,omitempty"`
+	// Bifurcated drivers keep the VF netdev while DPDK uses the VF, so
+	// the VF is never bound to a userspace driver
+	Bifurcated bool `json:"bifurcated" yaml:"bifurcated"`
+	// NeedsDPDKBind is set when DPDK requires the VF bound to dpdk_driver
+	NeedsDPDKBind bool `json:"needsDpdkBind" yaml:"needsDpdkBind"`
+	// VlanResetDelay is waited, in milliseconds, once the VF is back on its
+	// kernel driver before its VLAN is reset
+	VlanResetDelay   int  `json:"vlanResetDelayMs" yaml:"vlanResetDelayMs"`
+	SupportsTrust    bool `json:"supportsTrust" yaml:"supportsTrust"`
+	SupportsRate     bool `json:"supportsRate" yaml:"supportsRate"`
+	SupportsSpoofchk bool `json:"supportsSpoofchk" yaml:"supportsSpoofchk"`
+}
+
+// Unknown holds the capabilities assumed for VFs missing from the database:
+// none, so that the VF is neither bound to a userspace driver nor given
+// settings it may not support
+var Unknown = Device{
+	Name: "unknown",
+}
+
+// Known reports whether the device was found in the database
+func (d Device) Known() bool {
+	return d.Name != Unknown.Name
+}
+
+func intelVF(name, device string) Device {
+	return Device{
+		Name:             name,
+		Vendor:           "0x8086",
+		Device:           device,
+		Driver:           "intel",
+		NeedsDPDKBind:    true,
+		SupportsTrust:    true,
+		SupportsRate:     true,
+		SupportsSpoofchk: true,
+	}
+}
+
+func mellanoxVF(name, device string) Device {
+	return Device{
+		Name:             name,
+		Vendor:           "0x15b3",
+		Device:           device,
+		Driver:           "mellanox",
+		Bifurcated:       true,
+		SupportsTrust:    true,
+		SupportsRate:     true,
+		SupportsSpoofchk: true,
+	}
+}
+
+// builtin lists the VF models known without a device database file
+var builtin = []Device{
+	intelVF("82599 VF", "0x10ed"),
+	intelVF("X540 VF", "0x1515"),
+	intelVF("X550 VF", "0x1565"),
+	intelVF("XL710/X710 VF", "0x154c"),
+	intelVF("X722 VF", "0x37cd"),
+	intelVF("E810 VF", "0x1889"),
+	mellanoxVF("ConnectX-4 VF", "0x1014"),
+	mellanoxVF("ConnectX-4 Lx VF", "0x1016"),
+	mellanoxVF("ConnectX-5 VF", "0x1018"),
+	mellanoxVF("ConnectX-6 VF", "0x101c"),
+	mellanoxVF("ConnectX-6 Dx VF", "0x101e"),
+}
+
+var devices = make(map[string]Device)
+
+func init() {
+	for _, dev := range builtin {
+		devices[deviceKey(dev.Vendor, dev.Device)] = dev
+	}
+}
+
+func deviceKey(vendor, device string) string {
+	return strings.ToLower(vendor) + ":" + strings.ToLower(device)
+}
+
+// Load reads the device database file path, a list of Device in YAML if the
+// file is named *.yaml or *.yml and in JSON otherwise, whose entries take
+// precedence over the built-in ones. A missing file is not an error.
+func Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read device database %q: %v", path, err)
+	}
+
+	var list []Device
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &list)
+	default:
+		err = json.Unmarshal(data, &list)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse device database %q: %v", path, err)
+	}
+	for _, dev := range list {
+		if dev.Vendor == "" || dev.Device == "" {
+			return fmt.Errorf("device database %q has an entry without vendor or device ID", path)
+		}
+		devices[deviceKey(dev.Vendor, dev.Device)] = dev
+	}
+
+	return nil
+}
+
+func readPCIID(pciAddr, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(utils.SysBusPci, pciAddr, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the %s ID of %q: %v", name, pciAddr, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Lookup returns the capabilities of the PCI device pciAddr, Unknown ones if
+// its vendor and device IDs are not in the database
+func Lookup(pciAddr string) (Device, error) {
+	vendor, err := readPCIID(pciAddr, "vendor")
+	if err != nil {
+		return Device{}, err
+	}
+	device, err := readPCIID(pciAddr, "device")
+	if err != nil {
+		return Device{}, err
+	}
+
+	if dev, ok := devices[deviceKey(vendor, device)]; ok {
+		return dev, nil
+	}
+
+	dev := Unknown
+	dev.Vendor = vendor
+	dev.Device = device
+	return dev, nil
+}
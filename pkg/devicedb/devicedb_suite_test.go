@@ -0,0 +1,23 @@
+package devicedb
+
+import (
+	"testing"
+
+	"github.com/intel/sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDevicedb(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Devicedb Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// create test sys tree
+	Expect(utils.CreateTmpSysFs()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(utils.RemoveTmpSysFs()).To(Succeed())
+})
@@ -0,0 +1,108 @@
+package devicedb
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func writeDB(pattern, content string) string {
+	f, err := ioutil.TempFile("", pattern)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+	_, err = f.WriteString(content)
+	Expect(err).NotTo(HaveOccurred())
+	return f.Name()
+}
+
+var _ = Describe("Devicedb", func() {
+	Context("Checking Lookup function", func() {
+		It("Assuming built-in Intel VF", func() {
+			dev, err := Lookup("0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.Name).To(Equal("XL710/X710 VF"))
+			Expect(dev.NeedsDPDKBind).To(BeTrue())
+			Expect(dev.Bifurcated).To(BeFalse())
+		})
+		It("Assuming built-in Mellanox VF", func() {
+			dev, err := Lookup("0000:af:06.1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.Name).To(Equal("ConnectX-5 VF"))
+			Expect(dev.Bifurcated).To(BeTrue())
+		})
+		It("Assuming device missing from the database", func() {
+			dev, err := Lookup("0000:af:02.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.Name).To(Equal(Unknown.Name))
+			Expect(dev.Vendor).To(Equal("0x14e4"))
+			Expect(dev.NeedsDPDKBind).To(BeFalse(), "Unknown VFs should not be bound to a userspace driver")
+			Expect(dev.SupportsTrust || dev.SupportsRate || dev.SupportsSpoofchk).To(BeFalse(), "Unknown VFs should not be assumed to support any setting")
+		})
+		It("Assuming non-existing device", func() {
+			_, err := Lookup("0000:af:07.0")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking Load function", func() {
+		It("Assuming missing file", func() {
+			Expect(Load("/non/existing/devices.json")).To(Succeed())
+		})
+		It("Assuming YAML file", func() {
+			path := writeDB("devices*.yaml", `
+- name: I350 VF
+  vendor: "0x8086"
+  device: "0x1520"
+  driver: intel
+  needsDpdkBind: true
+  supportsSpoofchk: true
+`)
+			defer os.Remove(path)
+			Expect(Load(path)).To(Succeed())
+
+			dev, err := Lookup("0000:af:02.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.Name).To(Equal(Unknown.Name), "0000:af:02.0 is not an I350 VF")
+			Expect(devices[deviceKey("0x8086", "0x1520")]).To(Equal(Device{
+				Name:             "I350 VF",
+				Vendor:           "0x8086",
+				Device:           "0x1520",
+				Driver:           "intel",
+				NeedsDPDKBind:    true,
+				SupportsSpoofchk: true,
+			}))
+		})
+		It("Assuming YAML file with unknown field", func() {
+			path := writeDB("devices*.yml", `
+- name: broken
+  vendor: "0x8086"
+  device: "0x1520"
+  needsDPDKbind: true
+`)
+			defer os.Remove(path)
+			Expect(Load(path)).NotTo(Succeed(), "Misspelled capabilities should be refused")
+		})
+		It("Assuming entry without device ID", func() {
+			path := writeDB("devices*.json", `[{"name": "broken", "vendor": "0x8086"}]`)
+			defer os.Remove(path)
+			Expect(Load(path)).NotTo(Succeed())
+		})
+		It("Assuming malformed file", func() {
+			path := writeDB("devices*.json", `{"name": "broken"`)
+			defer os.Remove(path)
+			Expect(Load(path)).NotTo(Succeed())
+		})
+		It("Assuming entry overriding a built-in one", func() {
+			path := writeDB("devices*.json", `[{"name": "X710 VF no rate", "vendor": "0x8086", "device": "0x154C", "needsDpdkBind": true, "vlanResetDelayMs": 500, "supportsTrust": true, "supportsSpoofchk": true}]`)
+			defer os.Remove(path)
+			Expect(Load(path)).To(Succeed())
+
+			dev, err := Lookup("0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dev.Name).To(Equal("X710 VF no rate"))
+			Expect(dev.SupportsRate).To(BeFalse())
+			Expect(dev.VlanResetDelay).To(Equal(500))
+		})
+	})
+})
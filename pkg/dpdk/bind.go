@@ -0,0 +1,79 @@
+package dpdk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+// sysBusPciDir returns the sysfs pci bus directory holding the devices dir
+// utils.SysBusPci, its drivers and drivers_probe
+func sysBusPciDir() string {
+	return filepath.Dir(utils.SysBusPci)
+}
+
+func writeSysfs(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(value))
+	return err
+}
+
+// BindDriver binds the PCI device pciAddr to driver through sysfs and returns
+// the driver it was bound to before, "" if it was unbound. driver_override is
+// used so that any driver can be bound, including vfio-pci, igb_uio and
+// uio_pci_generic which do not list the VF in their id table.
+func BindDriver(pciAddr, driver string) (origDriver string, err error) {
+	if !utils.IsValidDriverName(driver) {
+		return "", fmt.Errorf("invalid driver name %q", driver)
+	}
+
+	// an unbound device has no driver link
+	origDriver, _ = utils.GetDriverName(pciAddr)
+	if origDriver == driver {
+		return origDriver, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(sysBusPciDir(), "drivers", driver)); err != nil {
+		return origDriver, fmt.Errorf("driver %q is not loaded: %v", driver, err)
+	}
+
+	devDir := filepath.Join(utils.SysBusPci, pciAddr)
+	overridePath := filepath.Join(devDir, "driver_override")
+	if err := writeSysfs(overridePath, driver); err != nil {
+		return origDriver, fmt.Errorf("failed to set driver_override of %q to %q: %v", pciAddr, driver, err)
+	}
+	// the override is cleared once probed so that it does not stick to the
+	// device after the VF is released
+	defer func() {
+		if clearErr := writeSysfs(overridePath, "\n"); clearErr != nil {
+			logging.Debugf("BindDriver failed to clear driver_override of %s: %v", pciAddr, clearErr)
+			if err == nil {
+				err = fmt.Errorf("failed to clear driver_override of %q: %v", pciAddr, clearErr)
+			}
+		}
+	}()
+
+	if origDriver != "" {
+		if err := writeSysfs(filepath.Join(devDir, "driver", "unbind"), pciAddr); err != nil {
+			return origDriver, fmt.Errorf("failed to unbind %q from %q: %v", pciAddr, origDriver, err)
+		}
+	}
+
+	if err := writeSysfs(filepath.Join(sysBusPciDir(), "drivers_probe"), pciAddr); err != nil {
+		return origDriver, fmt.Errorf("failed to probe %q for %q: %v", pciAddr, driver, err)
+	}
+
+	if bound, err := utils.GetDriverName(pciAddr); err != nil || bound != driver {
+		return origDriver, fmt.Errorf("%q was not bound to %q by the probe", pciAddr, driver)
+	}
+
+	return origDriver, nil
+}
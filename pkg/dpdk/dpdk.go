@@ -2,13 +2,11 @@ package dpdk
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"os/exec"
-	"path/filepath"
-	"strings"
+	"time"
+
+	"github.com/intel/sriov-cni/pkg/utils"
 )
 
 // Conf defines configuration related to dpdk driver binding/unbinding
@@ -19,93 +17,101 @@ type Conf struct {
 	DPDKDriver string `json:"dpdk_driver"`
 	DPDKtool   string `json:"dpdk_tool"`
 	VFID       int    `json:"vfid"`
+	// ReadyTimeout bounds the wait for the VF to be ready once bound back
+	// to its kernel driver, DefaultReadyTimeout if 0
+	ReadyTimeout int `json:"ready_timeout_ms,omitempty"`
+	// VfioUID and VfioGID own the vfio group device of VFs bound to
+	// vfio-pci while they are attached, if set
+	VfioUID *int `json:"vfio_uid,omitempty"`
+	VfioGID *int `json:"vfio_gid,omitempty"`
 }
 
-// ValidateConf vaildates dpdk configuration for required fields
-func ValidateConf() error {
-	return nil
-}
+// DefaultReadyTimeout is the default ReadyTimeout in milliseconds
+const DefaultReadyTimeout = 10000
 
-// GetConf takes in container ID, Pod interface name and data dir as string and returns a pointer to Conf
-func GetConf(cid, podIfName, dataDir string) (*Conf, error) {
-	s := []string{cid, podIfName}
-	cRef := strings.Join(s, "-")
+// ReadyWait returns ReadyTimeout, or DefaultReadyTimeout if not set
+func (dc *Conf) ReadyWait() time.Duration {
+	timeout := dc.ReadyTimeout
+	if timeout == 0 {
+		timeout = DefaultReadyTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
 
-	dpdkconfBytes, err := consumeScratchNetConf(cRef, dataDir)
-	if err != nil {
-		return nil, err
+// ValidateConf vaildates dpdk configuration for required fields
+func ValidateConf(dc *Conf) error {
+	if dc.DPDKDriver == "" {
+		return utils.NewConfError(utils.ErrInvalidDPDKConf, "dpdk dpdk_driver is required")
 	}
-	dc := &Conf{}
-	if err = json.Unmarshal(dpdkconfBytes, dc); err != nil {
-		return nil, fmt.Errorf("failed to parse netconf: %v", err)
+	// the kernel driver is detected at ADD when not given
+	if dc.KDriver != "" && !utils.IsValidDriverName(dc.KDriver) {
+		return utils.NewConfError(utils.ErrInvalidDriver, "invalid dpdk kernel_driver %q", dc.KDriver)
+	}
+	if utils.IsUserspaceDriver(dc.KDriver) {
+		return utils.NewConfError(utils.ErrInvalidDriver, "dpdk kernel_driver %q is a userspace driver", dc.KDriver)
+	}
+	if !utils.IsUserspaceDriver(dc.DPDKDriver) {
+		return utils.NewConfError(utils.ErrInvalidDriver, "dpdk dpdk_driver %q is not one of %v", dc.DPDKDriver, utils.UserspaceDrivers)
 	}
 
-	return dc, nil
-}
-
-// SaveDpdkConf takes in container ID, data dir as string and a pointer to Conf then save this Conf in data dir
-func SaveDpdkConf(cid, dataDir string, dc *Conf) error {
-	dpdkconfBytes, err := json.Marshal(dc)
-	if err != nil {
-		return fmt.Errorf("error serializing delegate netconf: %v", err)
+	if dc.ReadyTimeout < 0 {
+		return utils.NewConfError(utils.ErrInvalidDPDKConf, "dpdk ready_timeout_ms must not be negative")
 	}
 
-	s := []string{cid, dc.Ifname}
-	cRef := strings.Join(s, "-")
+	if dc.VfioUID != nil || dc.VfioGID != nil {
+		if dc.DPDKDriver != VfioDriver {
+			return utils.NewConfError(utils.ErrConflictingOptions, "dpdk vfio_uid and vfio_gid require dpdk_driver %s", VfioDriver)
+		}
+		if (dc.VfioUID != nil && *dc.VfioUID < 0) || (dc.VfioGID != nil && *dc.VfioGID < 0) {
+			return utils.NewConfError(utils.ErrInvalidDPDKConf, "dpdk vfio_uid and vfio_gid must not be negative")
+		}
+	}
 
-	// save the rendered netconf for cmdDel
-	if err = saveScratchNetConf(cRef, dataDir, dpdkconfBytes); err != nil {
-		return err
+	if dc.PCIaddr != "" && !utils.IsValidPCIAddress(dc.PCIaddr) {
+		return utils.NewConfError(utils.ErrInvalidPCIAddress, "invalid dpdk pci_addr %q", dc.PCIaddr)
 	}
 
 	return nil
 }
 
-func saveScratchNetConf(containerID, dataDir string, netconf []byte) error {
-	if err := os.MkdirAll(dataDir, 0700); err != nil {
-		return fmt.Errorf("failed to create the sriov data directory(%q): %v", dataDir, err)
-	}
-
-	path := filepath.Join(dataDir, containerID)
+//https://npf.io/2015/06/testing-exec-command
+var execCommand = exec.Command
 
-	err := ioutil.WriteFile(path, netconf, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
+// Enabledpdkmode binds the VF of Conf to the dpdk driver in Conf, or back to
+// its kernel driver if dpdkmode is false, and returns the driver the VF was
+// bound to before, "" if it was unbound. The VF is bound through sysfs, the
+// dpdk_tool script is only run if that fails.
+func Enabledpdkmode(dc *Conf, dpdkmode bool) (string, error) {
+	driver := dc.KDriver
+	if dpdkmode {
+		driver = dc.DPDKDriver
 	}
 
-	return err
-}
-
-func consumeScratchNetConf(containerID, dataDir string) ([]byte, error) {
-	path := filepath.Join(dataDir, containerID)
-	defer os.Remove(path)
-
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read container data in the path(%q): %v", path, err)
+	origDriver, err := BindDriver(dc.PCIaddr, driver)
+	if err == nil {
+		return origDriver, nil
+	}
+	if dc.DPDKtool == "" {
+		return origDriver, fmt.Errorf("failed to bind %q to %q: %v", dc.PCIaddr, driver, err)
 	}
 
-	return data, err
+	return origDriver, runDPDKtool(dc, dpdkmode)
 }
 
-//https://npf.io/2015/06/testing-exec-command
-var execCommand = exec.Command
-
-// Enabledpdkmode binds an interface given as ifname string to the dpdk driver in Conf
-func Enabledpdkmode(dc *Conf, ifname string, dpdkmode bool) error {
+// runDPDKtool binds the VF with the dpdk-devbind.py compatible dpdk_tool. The
+// VF is given by its PCI address, its netdev is gone once the sysfs bind
+// unbound it.
+func runDPDKtool(dc *Conf, dpdkmode bool) error {
 	stdout := &bytes.Buffer{}
 	var driver string
-	var device string
 
 	if dpdkmode != false {
 		driver = dc.DPDKDriver
-		device = ifname
 	} else {
 		driver = dc.KDriver
-		device = dc.PCIaddr
 	}
 
-	cmd := execCommand(dc.DPDKtool, "-b", driver, device)
+	cmd := execCommand(dc.DPDKtool, "-b", driver, dc.PCIaddr)
 	cmd.Stdout = stdout
 	err := cmd.Run()
 	if err != nil {
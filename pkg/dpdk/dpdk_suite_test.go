@@ -1,11 +1,9 @@
 package dpdk
 
 import (
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"testing"
 
+	"github.com/intel/sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -15,25 +13,11 @@ func TestDpdk(t *testing.T) {
 	RunSpecs(t, "Dpdk Suite")
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
-
-var tmpdir, dataDir string
-
 var _ = BeforeSuite(func() {
-	var err error
 	// create test sys tree
-	tmpdir, err = ioutil.TempDir("/tmp", "sriovplugin-testfiles-")
-	check(err)
-	// switch to test sys tree
-	dataDir = filepath.Join(tmpdir, "var/lib/cni/sriov")
+	Expect(utils.CreateTmpSysFs()).To(Succeed())
 })
 
 var _ = AfterSuite(func() {
-	var err error
-	err = os.RemoveAll(tmpdir)
-	check(err)
+	Expect(utils.RemoveTmpSysFs()).To(Succeed())
 })
@@ -2,23 +2,30 @@ package dpdk
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"testing"
 
+	"github.com/intel/sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
 //https://npf.io/2015/06/testing-exec-command
+// devbindDevice is the device the fake dpdk_tool expects, any if empty
+var devbindDevice string
+
 func FakeExecCommand(success bool) func(string, ...string) *exec.Cmd {
 	return func(command string, args ...string) *exec.Cmd {
 		cs := []string{"-test.run=TestHelperProcess", "--", command}
 		cs = append(cs, args...)
 		cmd := exec.Command(os.Args[0], cs...)
 		cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1",
-			fmt.Sprintf("DPDK_DEVBIND_SUCCESS=%s", strconv.FormatBool(success))}
+			fmt.Sprintf("DPDK_DEVBIND_SUCCESS=%s", strconv.FormatBool(success)),
+			fmt.Sprintf("DPDK_DEVBIND_DEVICE=%s", devbindDevice)}
 		return cmd
 	}
 }
@@ -32,6 +39,11 @@ func TestHelperProcess(t *testing.T) {
 		fmt.Fprintf(os.Stdout, "DPDK binding failed")
 		os.Exit(1)
 	}
+	// the device is the last argument, "-b driver device"
+	if device := os.Getenv("DPDK_DEVBIND_DEVICE"); device != "" && device != os.Args[len(os.Args)-1] {
+		fmt.Fprintf(os.Stdout, "unknown device %s", os.Args[len(os.Args)-1])
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
 
@@ -43,62 +55,109 @@ var _ = Describe("Dpdk", func() {
 		DPDKDriver: "vfio-pci",
 		DPDKtool:   "/opt/dpdk/usertools/dpdk-devbind.py",
 		VFID:       24}
-	Context("Checking SaveDdpkConf function", func() {
-		It("Assuming correct config file", func() {
-			err := SaveDpdkConf("cidCorrect", dataDir, &dc)
+	Context("Checking ValidateConf function", func() {
+		It("Assuming correct config", func() {
+			err := ValidateConf(&dc)
 			Expect(err).NotTo(HaveOccurred(), "Using correct configuration should not cause an error")
 		})
-		////TODO: this test will fail until ValidateDpdkConf will be added
-		//It("Assuming incorrect config file - missing pci address", func() {
-		//	dc.PCIaddr = ""
-		//	err := SaveDpdkConf("cidBroken", dataDir, &dc)
-		//	Expect(err).To(HaveOccurred(), "Using incorrect config file should cause an error")
-		//})
-	})
-	Context("Checking GetDdpkConf function", func() {
-		It("Assuming correct config file", func() {
-			dc.PCIaddr = "0000:af:09.0"
-			_, err := GetConf("cidCorrect", "net1", dataDir)
-			Expect(err).NotTo(HaveOccurred(), "Using correct configuration should not cause an error")
+		It("Assuming missing kernel driver", func() {
+			c := dc
+			c.KDriver = ""
+			err := ValidateConf(&c)
+			Expect(err).NotTo(HaveOccurred(), "Missing kernel driver should be detected at ADD")
+		})
+		It("Assuming kernel driver given as dpdk driver", func() {
+			c := dc
+			c.DPDKDriver = "i40evf"
+			err := ValidateConf(&c)
+			Expect(err).To(HaveOccurred(), "Kernel driver as dpdk driver should cause an error")
+		})
+		It("Assuming negative ready timeout", func() {
+			c := dc
+			c.ReadyTimeout = -1
+			err := ValidateConf(&c)
+			Expect(err).To(HaveOccurred(), "Negative ready timeout should cause an error")
+		})
+		It("Assuming malformed pci address", func() {
+			c := dc
+			c.PCIaddr = "af:09.0"
+			err := ValidateConf(&c)
+			Expect(err).To(HaveOccurred(), "Malformed pci address should cause an error")
+		})
+		It("Assuming vfio owner with a driver other than vfio-pci", func() {
+			c := dc
+			c.DPDKDriver = "igb_uio"
+			uid := 1000
+			c.VfioUID = &uid
+			err := ValidateConf(&c)
+			Expect(err).To(HaveOccurred(), "vfio_uid without vfio-pci should cause an error")
 		})
-		////TODO: this test will fail until ValidateDpdkConf will be added
-		//It("Assuming incorrect config file - missing pci address", func() {
-		//	dc.PCIaddr = ""
-		//	_, err := GetConf("cidBroken", "net1", dataDir)
-		//	//TODO: this test will fail until ValidateDpdkConf will be added
-		//	Expect(err).To(HaveOccurred(), "Using incorrect config file should cause an error")
-		//})
-		It("Assuming not existing config file", func() {
-			_, err := GetConf("cid", "net1", dataDir)
-			Expect(err).To(HaveOccurred(), "Using not existing config file should cause an error")
+		It("Assuming negative vfio gid", func() {
+			c := dc
+			gid := -1
+			c.VfioGID = &gid
+			err := ValidateConf(&c)
+			Expect(err).To(HaveOccurred(), "Negative vfio_gid should cause an error")
 		})
 	})
 	Context("Checking Enabledpdkmode function", func() {
 		It("Assuming dpdk mode enabled with correct config file", func() {
 			dc.PCIaddr = "0000:af:09.0"
+			// the netdev of the VF is gone once sysfs unbound it
+			devbindDevice = dc.PCIaddr
 			execCommand = FakeExecCommand(true)
-			defer func() { execCommand = exec.Command }()
-			err := Enabledpdkmode(&dc, "net1", true)
+			defer func() { execCommand, devbindDevice = exec.Command, "" }()
+			_, err := Enabledpdkmode(&dc, true)
 			Expect(err).NotTo(HaveOccurred(), "Using correct config file should not cause an error")
 		})
 		It("Assuming dpdk mode disabled with correct config file", func() {
+			// the netdev of the VF is gone once sysfs unbound it
+			devbindDevice = dc.PCIaddr
 			execCommand = FakeExecCommand(true)
-			defer func() { execCommand = exec.Command }()
-			err := Enabledpdkmode(&dc, "net1", false)
+			defer func() { execCommand, devbindDevice = exec.Command, "" }()
+			_, err := Enabledpdkmode(&dc, false)
 			Expect(err).NotTo(HaveOccurred(), "Using correct config file should not cause an error")
 		})
+		It("Assuming dpdk_tool fallback with the VF bound to its kernel driver", func() {
+			c := dc
+			c.PCIaddr = "0000:af:06.0"
+			execCommand = FakeExecCommand(true)
+			defer func() { execCommand = exec.Command }()
+			origDriver, err := Enabledpdkmode(&c, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(origDriver).To(Equal("i40evf"), "The driver found before the bind should be returned")
+		})
 		It("Assuming dpdk mode enabled with incorrect config file - missing dpdk tool path", func() {
 			dc.DPDKtool = ""
 			execCommand = FakeExecCommand(false)
 			defer func() { execCommand = exec.Command }()
-			err := Enabledpdkmode(&dc, "net1", true)
+			_, err := Enabledpdkmode(&dc, true)
 			Expect(err).To(HaveOccurred(), "Using incorrect config file should cause an error")
 		})
 		It("Assuming dpdk mode disabled with incorrect config file - missing dpdk tool path", func() {
 			execCommand = FakeExecCommand(false)
 			defer func() { execCommand = exec.Command }()
-			err := Enabledpdkmode(&dc, "net1", false)
+			_, err := Enabledpdkmode(&dc, false)
 			Expect(err).To(HaveOccurred(), "Using incorrect config file should cause an error")
 		})
 	})
+	Context("Checking BindDriver function", func() {
+		It("Assuming device already bound to the driver", func() {
+			Expect(BindDriver("0000:af:06.0", "i40evf")).To(Equal("i40evf"))
+		})
+		It("Assuming driver not loaded", func() {
+			_, err := BindDriver("0000:af:06.0", "igb_uio")
+			Expect(err).To(HaveOccurred(), "Binding to a driver not loaded should cause an error")
+		})
+		It("Assuming probe not binding the device", func() {
+			_, err := BindDriver("0000:af:06.1", "vfio-pci")
+			Expect(err).To(HaveOccurred(), "Device left unbound by the probe should cause an error")
+			override, err := ioutil.ReadFile(filepath.Join(utils.SysBusPci, "0000:af:06.1", "driver_override"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(override)).To(Equal("\n"), "driver_override should be cleared")
+			probed, err := ioutil.ReadFile(filepath.Join(filepath.Dir(utils.SysBusPci), "drivers_probe"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(probed)).To(Equal("0000:af:06.1"))
+		})
+	})
 })
@@ -0,0 +1,132 @@
+package dpdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+// VfioDriver is the userspace driver whose devices are handed out per IOMMU
+// group
+const VfioDriver = "vfio-pci"
+
+// vfioViableDrivers are the drivers the kernel accepts alongside vfio-pci in a
+// vfio IOMMU group: they never do DMA on behalf of the host
+var vfioViableDrivers = map[string]bool{
+	VfioDriver: true,
+	"pci-stub": true,
+	"pcieport": true,
+}
+
+// VfioDir holds the device nodes of the IOMMU groups bound to vfio
+var VfioDir = "/dev/vfio"
+
+// vfioPollInterval is the polling period of the group device node
+const vfioPollInterval = 50 * time.Millisecond
+
+// VfioMode is the permissions the group device node is given with its new
+// owner, so that the owner or the group can open it
+const VfioMode os.FileMode = 0660
+
+// VfioOwner is the owner and the permissions of a group device node
+type VfioOwner struct {
+	UID  int
+	GID  int
+	Mode os.FileMode
+}
+
+// IOMMUGroup returns the IOMMU group of the PCI device pciAddr
+func IOMMUGroup(pciAddr string) (string, error) {
+	groupPath, err := filepath.EvalSymlinks(filepath.Join(utils.SysBusPci, pciAddr, "iommu_group"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the IOMMU group of %q, is the IOMMU enabled: %v", pciAddr, err)
+	}
+	return filepath.Base(groupPath), nil
+}
+
+// GroupPath returns the vfio device node of the IOMMU group
+func GroupPath(group string) string {
+	return filepath.Join(VfioDir, group)
+}
+
+// CheckIOMMUGroup verifies that the IOMMU group of the PCI device pciAddr is
+// viable for vfio as the kernel sees it: every other device of the group must
+// be unbound or bound to vfio-pci, pci-stub or pcieport, so that VFs behind a
+// PCIe bridge can be used. It returns a *types.Error.
+func CheckIOMMUGroup(pciAddr string) error {
+	devicesDir := filepath.Join(utils.SysBusPci, pciAddr, "iommu_group", "devices")
+	devices, err := ioutil.ReadDir(devicesDir)
+	if err != nil {
+		return utils.NewError(utils.ErrIOMMUGroupNotViable, "", "failed to list the IOMMU group of %q: %v", pciAddr, err)
+	}
+
+	for _, dev := range devices {
+		if dev.Name() == pciAddr {
+			continue
+		}
+		// an unbound device has no driver link
+		driver, err := utils.GetDriverName(dev.Name())
+		if err == nil && !vfioViableDrivers[driver] {
+			return utils.NewError(utils.ErrIOMMUGroupNotViable, "", "device %q of the IOMMU group of %q is bound to %q", dev.Name(), pciAddr, driver)
+		}
+	}
+	return nil
+}
+
+// SetVfioOwner changes the owner of the vfio device node of group to uid and
+// gid, -1 keeps the current one, its permissions to VfioMode, and returns the
+// previous owner and permissions. The node appears asynchronously once the
+// group is bound, it is waited for timeout.
+func SetVfioOwner(group string, uid, gid int, timeout time.Duration) (VfioOwner, error) {
+	path := GroupPath(group)
+	deadline := time.Now().Add(timeout)
+	var info os.FileInfo
+	var err error
+	for {
+		if info, err = os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return VfioOwner{}, fmt.Errorf("vfio group device %q not found: %v", path, err)
+		}
+		time.Sleep(vfioPollInterval)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return VfioOwner{}, fmt.Errorf("failed to read the owner of %q", path)
+	}
+	orig := VfioOwner{UID: int(stat.Uid), GID: int(stat.Gid), Mode: info.Mode().Perm()}
+
+	if err = os.Chown(path, uid, gid); err != nil {
+		return VfioOwner{}, fmt.Errorf("failed to change the owner of %q to %d:%d: %v", path, uid, gid, err)
+	}
+	if err = os.Chmod(path, VfioMode); err != nil {
+		// the node is not handed over half way
+		os.Chown(path, orig.UID, orig.GID)
+		return VfioOwner{}, fmt.Errorf("failed to change the permissions of %q to %o: %v", path, VfioMode, err)
+	}
+	return orig, nil
+}
+
+// RestoreVfioOwner gives the vfio device node of group its owner and
+// permissions orig back, a missing node is not an error as the group was
+// released with it
+func RestoreVfioOwner(group string, orig VfioOwner) error {
+	path := GroupPath(group)
+	if err := os.Chown(path, orig.UID, orig.GID); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to restore the owner of %q to %d:%d: %v", path, orig.UID, orig.GID, err)
+	}
+	if err := os.Chmod(path, orig.Mode); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to restore the permissions of %q to %o: %v", path, orig.Mode, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package dpdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vfio", func() {
+	Context("Checking IOMMUGroup function", func() {
+		It("Assuming device in an IOMMU group", func() {
+			Expect(IOMMUGroup("0000:af:06.0")).To(Equal("40"))
+		})
+		It("Assuming device without IOMMU group", func() {
+			_, err := IOMMUGroup("0000:af:02.0")
+			Expect(err).To(HaveOccurred(), "Device without IOMMU group should cause an error")
+		})
+	})
+	Context("Checking CheckIOMMUGroup function", func() {
+		It("Assuming IOMMU group shared with a PCIe bridge", func() {
+			Expect(CheckIOMMUGroup("0000:af:06.0")).To(Succeed(), "Group member bound to pcieport should be allowed")
+		})
+		It("Assuming IOMMU group shared with a device bound to a kernel driver", func() {
+			err := CheckIOMMUGroup("0000:af:06.1")
+			Expect(err).To(HaveOccurred(), "Group member bound to i40e should cause an error")
+			Expect(err.(*types.Error).Code).To(Equal(utils.ErrIOMMUGroupNotViable))
+		})
+	})
+	Context("Checking SetVfioOwner and RestoreVfioOwner functions", func() {
+		var origVfioDir string
+		BeforeEach(func() {
+			origVfioDir = VfioDir
+			dir, err := ioutil.TempDir("", "sriovplugin-vfio-")
+			Expect(err).NotTo(HaveOccurred())
+			VfioDir = dir
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(VfioDir)).To(Succeed())
+			VfioDir = origVfioDir
+		})
+		It("Assuming group device node present", func() {
+			path := filepath.Join(VfioDir, "40")
+			Expect(ioutil.WriteFile(path, nil, 0600)).To(Succeed())
+			orig, err := SetVfioOwner("40", os.Getuid(), os.Getgid(), time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orig).To(Equal(VfioOwner{UID: os.Getuid(), GID: os.Getgid(), Mode: 0600}))
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(VfioMode), "The node should be opened to its new owner")
+
+			Expect(RestoreVfioOwner("40", orig)).To(Succeed())
+			info, err = os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)), "The permissions should be restored")
+		})
+		It("Assuming group device node never created", func() {
+			_, err := SetVfioOwner("40", os.Getuid(), os.Getgid(), 100*time.Millisecond)
+			Expect(err).To(HaveOccurred(), "Missing group device node should cause an error")
+		})
+		It("Assuming group device node removed before the restore", func() {
+			Expect(RestoreVfioOwner("40", VfioOwner{UID: os.Getuid(), GID: os.Getgid(), Mode: 0600})).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/utils"
+)
+
+// SchemaVersion is the version of the State layout written by Save, states
+// written with a newer version are refused by Load
+const SchemaVersion = 1
+
+// ErrNotFound is returned by Load when no state is recorded for an attachment
+var ErrNotFound = errors.New("no state recorded")
+
+// OrigVF holds the VF settings found before ADD, restored on release
+type OrigVF struct {
+	MAC       string `json:"mac,omitempty"`
+	NetdevMAC string `json:"netdev_mac,omitempty"`
+	Vlan      int    `json:"vlan"`
+	Qos       int    `json:"qos"`
+	Spoofchk  bool   `json:"spoofchk"`
+	Trust     bool   `json:"trust"`
+	LinkState int    `json:"link_state"`
+	MTU       int    `json:"mtu,omitempty"`
+	Driver    string `json:"driver,omitempty"`
+	VfioUID   int    `json:"vfio_uid,omitempty"`
+	VfioGID   int    `json:"vfio_gid,omitempty"`
+	// VfioMode is the permissions of the vfio group device node before ADD
+	VfioMode os.FileMode `json:"vfio_mode,omitempty"`
+}
+
+// VF records one VF attached to the pod
+type VF struct {
+	PFName     string `json:"pf"`
+	VFID       int    `json:"vf"`
+	PCIAddr    string `json:"pci_addr"`
+	HostIfName string `json:"host_ifname,omitempty"`
+	// HostNamePolicy is the hostNamePolicy naming the netdev on release,
+	// the original name is restored if empty
+	HostNamePolicy string     `json:"host_name_policy,omitempty"`
+	PodIfName      string     `json:"pod_ifname"`
+	Vlan           int        `json:"vlan"`
+	VlanQoS        int        `json:"vlan_qos,omitempty"`
+	VlanProto      string     `json:"vlan_proto,omitempty"`
+	MAC            string     `json:"mac,omitempty"`
+	SpoofChk       string     `json:"spoofchk,omitempty"`
+	Trust          string     `json:"trust,omitempty"`
+	LinkState      string     `json:"link_state,omitempty"`
+	MinTxRate      int        `json:"min_tx_rate,omitempty"`
+	MaxTxRate      int        `json:"max_tx_rate,omitempty"`
+	MTU            int        `json:"mtu,omitempty"`
+	L2Mode         bool       `json:"l2enable"`
+	DPDKConf       *dpdk.Conf `json:"dpdk,omitempty"`
+	DPDKBound      bool       `json:"dpdk_bound"`
+	// IOMMUGroup is the IOMMU group of VFs bound to vfio-pci
+	IOMMUGroup string `json:"iommu_group,omitempty"`
+	Orig       OrigVF `json:"orig"`
+}
+
+// Bond records the bond created in the pod over the VFs
+type Bond struct {
+	Name   string   `json:"name"`
+	Mode   string   `json:"mode"`
+	Slaves []string `json:"slaves"`
+}
+
+// State is the outcome of ADD for one attachment, keyed by container ID and
+// pod interface name
+type State struct {
+	Version     int    `json:"version"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+	Netns       string `json:"netns"`
+	VFs         []*VF  `json:"vfs"`
+	Bond        *Bond  `json:"bond,omitempty"`
+}
+
+// New returns an empty State for the attachment ifName of container cid
+func New(cid, ifName, netns string) *State {
+	return &State{
+		Version:     SchemaVersion,
+		ContainerID: cid,
+		IfName:      ifName,
+		Netns:       netns,
+		VFs:         make([]*VF, 0),
+	}
+}
+
+func statePath(dataDir, cid, ifName string) string {
+	return filepath.Join(dataDir, fmt.Sprintf("%s-%s.json", cid, ifName))
+}
+
+// Save writes s in dataDir, replacing the previous state of the attachment
+// atomically
+func Save(dataDir string, s *State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to serialize state: %v", err)
+	}
+
+	if err = os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the sriov data directory(%q): %v", dataDir, err)
+	}
+
+	path := statePath(dataDir, s.ContainerID, s.IfName)
+	if err = utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file %q: %v", path, err)
+	}
+
+	return nil
+}
+
+// Load returns the state of the attachment ifName of container cid, or
+// ErrNotFound if none is recorded
+func Load(dataDir, cid, ifName string) (*State, error) {
+	path := statePath(dataDir, cid, ifName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read state file %q: %v", path, err)
+	}
+
+	s := &State{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %q: %v", path, err)
+	}
+	if s.Version < 1 || s.Version > SchemaVersion {
+		return nil, fmt.Errorf("state file %q has unsupported version %d", path, s.Version)
+	}
+
+	return s, nil
+}
+
+// Delete removes the state of the attachment ifName of container cid, a
+// missing state is not an error
+func Delete(dataDir, cid, ifName string) error {
+	path := statePath(dataDir, cid, ifName)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove state file %q: %v", path, err)
+	}
+	return nil
+}
+
+// VFByPodIfName returns the VF moved into the pod as ifName
+func (s *State) VFByPodIfName(ifName string) *VF {
+	for _, vf := range s.VFs {
+		if vf.PodIfName == ifName {
+			return vf
+		}
+	}
+	return nil
+}
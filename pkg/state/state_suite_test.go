@@ -0,0 +1,37 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Suite")
+}
+
+func check(e error) {
+	if e != nil {
+		panic(e)
+	}
+}
+
+var tmpdir, dataDir string
+
+var _ = BeforeSuite(func() {
+	var err error
+	tmpdir, err = ioutil.TempDir("/tmp", "sriovplugin-testfiles-")
+	check(err)
+	dataDir = filepath.Join(tmpdir, "var/lib/cni/sriov")
+})
+
+var _ = AfterSuite(func() {
+	var err error
+	err = os.RemoveAll(tmpdir)
+	check(err)
+})
@@ -0,0 +1,62 @@
+package state
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State", func() {
+	s := New("cid", "net1", "/var/run/netns/pod")
+	s.VFs = append(s.VFs, &VF{
+		PFName:     "enp175s0f1",
+		VFID:       0,
+		PCIAddr:    "0000:af:06.0",
+		HostIfName: "enp175s6",
+		PodIfName:  "net1",
+		Vlan:       100,
+		DPDKConf:   &dpdk.Conf{KDriver: "i40evf", DPDKDriver: "vfio-pci"},
+		Orig:       OrigVF{MAC: "aa:bb:cc:dd:ee:ff", Spoofchk: true, Driver: "i40evf"},
+	})
+
+	Context("Checking Save and Load functions", func() {
+		It("Assuming saved state", func() {
+			Expect(Save(dataDir, s)).To(Succeed(), "Saving a state should not cause an error")
+			loaded, err := Load(dataDir, "cid", "net1")
+			Expect(err).NotTo(HaveOccurred(), "Loading a saved state should not cause an error")
+			Expect(loaded).To(Equal(s), "Loaded state should match the saved one")
+		})
+		It("Assuming not existing state", func() {
+			_, err := Load(dataDir, "cid", "net2")
+			Expect(err).To(Equal(ErrNotFound), "Loading a not existing state should return ErrNotFound")
+		})
+		It("Assuming state of a newer version", func() {
+			err := ioutil.WriteFile(filepath.Join(dataDir, "cid-net3.json"), []byte(`{"version": 99}`), 0600)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = Load(dataDir, "cid", "net3")
+			Expect(err).To(HaveOccurred(), "Loading a state of a newer version should cause an error")
+		})
+	})
+	Context("Checking Delete function", func() {
+		It("Assuming saved state", func() {
+			Expect(Save(dataDir, s)).To(Succeed())
+			Expect(Delete(dataDir, "cid", "net1")).To(Succeed(), "Deleting a saved state should not cause an error")
+			_, err := Load(dataDir, "cid", "net1")
+			Expect(err).To(Equal(ErrNotFound), "Deleted state should not be found")
+		})
+		It("Assuming not existing state", func() {
+			Expect(Delete(dataDir, "cid", "net4")).To(Succeed(), "Deleting a not existing state should not cause an error")
+		})
+	})
+	Context("Checking VFByPodIfName function", func() {
+		It("Assuming existing pod interface", func() {
+			Expect(s.VFByPodIfName("net1")).To(Equal(s.VFs[0]))
+		})
+		It("Assuming not existing pod interface", func() {
+			Expect(s.VFByPodIfName("net2")).To(BeNil())
+		})
+	})
+})
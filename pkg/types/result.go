@@ -0,0 +1,93 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Interface describes a network interface created or moved by the plugin
+type Interface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+	// PCIAddr is the PCI address of the VF behind the interface, the only
+	// handle on VFs bound to a userspace driver
+	PCIAddr string `json:"pciAddr,omitempty"`
+	// VfioGroup is the vfio device node of the IOMMU group of VFs bound to
+	// vfio-pci
+	VfioGroup string `json:"vfioGroup,omitempty"`
+}
+
+// IPConfig contains an IP address assigned to one of the Result interfaces
+type IPConfig struct {
+	Version   string      `json:"version"`
+	Interface *int        `json:"interface,omitempty"`
+	Address   types.IPNet `json:"address"`
+	Gateway   net.IP      `json:"gateway,omitempty"`
+}
+
+// Result is the CNI 0.3.x result returned by cmdAdd. The vendored CNI library
+// only knows the legacy 0.2.0 ip4/ip6 result, so it is kept here.
+type Result struct {
+	CNIVersion string         `json:"cniVersion,omitempty"`
+	Interfaces []*Interface   `json:"interfaces,omitempty"`
+	IPs        []*IPConfig    `json:"ips,omitempty"`
+	Routes     []*types.Route `json:"routes,omitempty"`
+	DNS        types.DNS      `json:"dns,omitempty"`
+}
+
+// AddInterface appends iface to the Result and returns its index
+func (r *Result) AddInterface(iface *Interface) int {
+	r.Interfaces = append(r.Interfaces, iface)
+	return len(r.Interfaces) - 1
+}
+
+// AddLegacyResult merges a 0.2.0 IPAM result into the Result, binding the
+// addresses to the interface at index ifIndex
+func (r *Result) AddLegacyResult(res *types.Result, ifIndex int) {
+	if res == nil {
+		return
+	}
+
+	for _, ipc := range []*types.IPConfig{res.IP4, res.IP6} {
+		if ipc == nil {
+			continue
+		}
+		version := "4"
+		if ipc.IP.IP.To4() == nil {
+			version = "6"
+		}
+		idx := ifIndex
+		r.IPs = append(r.IPs, &IPConfig{
+			Version:   version,
+			Interface: &idx,
+			Address:   types.IPNet(ipc.IP),
+			Gateway:   ipc.Gateway,
+		})
+		for i := range ipc.Routes {
+			route := ipc.Routes[i]
+			if route.GW == nil {
+				route.GW = ipc.Gateway
+			}
+			r.Routes = append(r.Routes, &route)
+		}
+	}
+
+	if len(res.DNS.Nameservers) > 0 || res.DNS.Domain != "" {
+		r.DNS = res.DNS
+	}
+}
+
+// Print writes the Result as JSON to stdout
+func (r *Result) Print() error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize result: %v", err)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -1,8 +1,13 @@
 package types
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/utils"
 )
 
 // VfInformation holds VF specific informaiton
@@ -12,17 +17,122 @@ type VfInformation struct {
 	Vfid    int    `json:"vfid"`
 }
 
+// BondConf holds the options of the bond created in the pod when several
+// VFs are given in deviceID
+type BondConf struct {
+	Mode           string `json:"mode"`
+	Miimon         *int   `json:"miimon,omitempty"`
+	XmitHashPolicy string `json:"xmit_hash_policy"`
+	FailOverMac    string `json:"fail_over_mac"`
+	LacpRate       string `json:"lacp_rate"`
+}
+
+// DeviceConf describes one VF of a multi-device configuration and the
+// options overriding the NetConf ones for this VF
+type DeviceConf struct {
+	DeviceID  string     `json:"deviceID"`
+	Vlan      *int       `json:"vlan,omitempty"`
+	MAC       string     `json:"mac,omitempty"`
+	MinTxRate *int       `json:"min_tx_rate,omitempty"`
+	MaxTxRate *int       `json:"max_tx_rate,omitempty"`
+	DPDKConf  *dpdk.Conf `json:"dpdk,omitempty"`
+	IfName    string     `json:"ifname,omitempty"`
+}
+
+// minRangeVlanID and maxRangeVlanID bound the ranges of VlanList, a range is
+// a pool of tagged VLANs so VLAN 0, untagged, is not part of it
+const (
+	minRangeVlanID = 1
+	maxRangeVlanID = 4094
+)
+
+// VlanList holds VLAN IDs given in JSON as IDs or "first-last" ranges
+type VlanList []int
+
+// UnmarshalJSON implements json.Unmarshaler, ranges are expanded once their
+// bounds are checked. Invalid VLANs are reported as utils.ErrInvalidVlan.
+func (l *VlanList) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	vlans := make(VlanList, 0, len(items))
+	for _, item := range items {
+		var vlan int
+		if err := json.Unmarshal(item, &vlan); err == nil {
+			vlans = append(vlans, vlan)
+			continue
+		}
+
+		var r string
+		if err := json.Unmarshal(item, &r); err != nil {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan %s, expected an ID or a range", item)
+		}
+		bounds := strings.SplitN(r, "-", 2)
+		if len(bounds) != 2 {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan range %q, expected first-last", r)
+		}
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan range %q: %v", r, err)
+		}
+		last, err := strconv.Atoi(bounds[1])
+		if err != nil {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan range %q: %v", r, err)
+		}
+		if first < minRangeVlanID || last > maxRangeVlanID {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan range %q, out of the %d-%d range", r, minRangeVlanID, maxRangeVlanID)
+		}
+		if first > last {
+			return utils.NewConfError(utils.ErrInvalidVlan, "invalid vlan range %q, %d is above %d", r, first, last)
+		}
+		for vlan = first; vlan <= last; vlan++ {
+			vlans = append(vlans, vlan)
+		}
+	}
+
+	*l = vlans
+	return nil
+}
+
+// RuntimeConfig holds the capability arguments passed by the runtime
+type RuntimeConfig struct {
+	Mac  string `json:"mac,omitempty"`
+	Vlan int    `json:"vlan,omitempty"`
+}
+
 // NetConf extends types.NetConf for sriov-cni
 type NetConf struct {
 	types.NetConf
-	DPDKMode   bool
-	Sharedvf   bool
-	DPDKConf   *dpdk.Conf     `json:"dpdk,omitempty"`
-	CNIDir     string         `json:"cniDir"`
-	Master     string         `json:"master"`
-	L2Mode     bool           `json:"l2enable"`
-	Vlan       int            `json:"vlan"`
-	Vlans      []int          `json:"vlans"`
-	DeviceID   string         `json:"deviceID"`
-	DeviceInfo *VfInformation `json:"deviceinfo,omitempty"`
+	DPDKMode       bool
+	Sharedvf       bool
+	DPDKConf       *dpdk.Conf     `json:"dpdk,omitempty"`
+	CNIDir         string         `json:"cniDir"`
+	DeviceDB       string         `json:"deviceDB,omitempty"`
+	HostNamePolicy string         `json:"hostNamePolicy,omitempty"`
+	Master         string         `json:"master"`
+	L2Mode         bool           `json:"l2enable"`
+	Vlan           int            `json:"vlan"`
+	Vlans          VlanList       `json:"vlans"`
+	VlanSelection  string         `json:"vlanSelection,omitempty"`
+	VlanQoS        int            `json:"vlanQoS,omitempty"`
+	VlanProto      string         `json:"vlanProto,omitempty"`
+	MTU            int            `json:"mtu,omitempty"`
+	AdjustPfMtu    bool           `json:"adjustPfMtu,omitempty"`
+	MAC            string         `json:"mac,omitempty"`
+	SpoofChk       string         `json:"spoofchk,omitempty"`
+	Trust          string         `json:"trust,omitempty"`
+	LinkState      string         `json:"link_state,omitempty"`
+	MinTxRate      int            `json:"min_tx_rate,omitempty"`
+	MaxTxRate      int            `json:"max_tx_rate,omitempty"`
+	DeviceID       string         `json:"deviceID"`
+	Devices        []DeviceConf   `json:"devices,omitempty"`
+	DeviceInfo     *VfInformation `json:"deviceinfo,omitempty"`
+	Bond           *BondConf      `json:"bond,omitempty"`
+	PrevResult     *Result        `json:"prevResult,omitempty"`
+	// RuntimeConfig.Mac overrides MAC when the mac capability is enabled
+	RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+	// PodIfName is the pod interface name requested for a device
+	PodIfName string `json:"-"`
 }
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Error codes defined by the CNI specification which are missing from the
+// vendored CNI library
+const (
+	// ErrInvalidEnvironmentVariables is returned when a required CNI_*
+	// variable is missing
+	ErrInvalidEnvironmentVariables uint = 4
+	// ErrDecodingFailure is returned when the network configuration can't be parsed
+	ErrDecodingFailure uint = 6
+	// ErrInvalidNetworkConfig is returned when the network configuration is invalid
+	ErrInvalidNetworkConfig uint = 7
+)
+
+// Error codes specific to sriov-cni, the CNI specification reserves the
+// codes from 100 for plugins
+const (
+	// ErrInvalidVlan is returned for VLAN IDs out of the 0-4094 range
+	ErrInvalidVlan uint = 100 + iota
+	// ErrInvalidPCIAddress is returned for malformed PCI addresses
+	ErrInvalidPCIAddress
+	// ErrInvalidMAC is returned for malformed MAC addresses
+	ErrInvalidMAC
+	// ErrInvalidDPDKConf is returned when required DPDK options are missing
+	ErrInvalidDPDKConf
+	// ErrInvalidDriver is returned for unknown or malformed driver names
+	ErrInvalidDriver
+	// ErrConflictingOptions is returned when mutually exclusive options are set
+	ErrConflictingOptions
+	// ErrDeviceNotFound is returned when a VF can't be resolved from sysfs
+	ErrDeviceNotFound
+	// ErrInvalidBondConf is returned for invalid bond options
+	ErrInvalidBondConf
+	// ErrCheckFailed is returned by CHECK when the attachment drifted from
+	// the state set up by ADD
+	ErrCheckFailed
+	// ErrDeviceBusy is returned when a VF is reserved by another attachment
+	ErrDeviceBusy
+	// ErrInvalidVfSetting is returned for invalid VF settings applied on the PF
+	ErrInvalidVfSetting
+	// ErrVlanPoolExhausted is returned when all the VLANs of the pool are
+	// leased to other attachments
+	ErrVlanPoolExhausted
+	// ErrIOMMUGroupNotViable is returned when the IOMMU group of a VF holds
+	// devices bound to a driver other than vfio-pci, pci-stub or pcieport
+	ErrIOMMUGroupNotViable
+	// ErrUnknownDevice is returned when DPDK mode is requested for a VF
+	// missing from the device database
+	ErrUnknownDevice
+)
+
+// NewError returns a CNI error with the given code and message
+func NewError(code uint, details string, format string, args ...interface{}) *types.Error {
+	return &types.Error{
+		Code:    code,
+		Msg:     fmt.Sprintf(format, args...),
+		Details: details,
+	}
+}
+
+// NewConfError returns a CNI error for an invalid network configuration, the
+// message is prefixed so that all configuration errors read the same
+func NewConfError(code uint, format string, args ...interface{}) *types.Error {
+	return NewError(code, "", "error: SRIOV-CNI loadConf: "+format, args...)
+}
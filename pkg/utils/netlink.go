@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// VF netlink attributes missing from the vendored netlink package
+const (
+	iflaVfTrust      = 9
+	iflaVfVlanList   = 12
+	iflaVfVlanInfo   = 1
+	rtextFilterVf    = 1
+	nlaTypeMask      = ^uint16(syscall.NLA_F_NESTED | 0x4000)
+	sizeofVfTrust    = 0x08
+	sizeofVfVlanInfo = 0x10
+	ethPVlan8021Q    = 0x8100
+	ethPVlan8021AD   = 0x88a8
+)
+
+// VLAN protocols of a VF
+const (
+	VlanProto8021Q  = "802.1Q"
+	VlanProto8021AD = "802.1ad"
+)
+
+// VlanProtos maps the VF VLAN protocol names to their ethertype
+var VlanProtos = map[string]uint16{
+	VlanProto8021Q:  ethPVlan8021Q,
+	VlanProto8021AD: ethPVlan8021AD,
+}
+
+// VfTrust is the ifla_vf_trust struct missing from the vendored netlink package
+type VfTrust struct {
+	Vf      uint32
+	Setting uint32
+}
+
+// Serialize returns the netlink encoding of msg
+func (msg *VfTrust) Serialize() []byte {
+	native := nl.NativeEndian()
+	b := make([]byte, sizeofVfTrust)
+	native.PutUint32(b[0:4], msg.Vf)
+	native.PutUint32(b[4:8], msg.Setting)
+	return b
+}
+
+// VfVlanInfo is the ifla_vf_vlan_info struct missing from the vendored
+// netlink package
+type VfVlanInfo struct {
+	Vf        uint32
+	Vlan      uint32
+	Qos       uint32
+	VlanProto uint16
+}
+
+// Serialize returns the netlink encoding of msg, the protocol is in network
+// byte order
+func (msg *VfVlanInfo) Serialize() []byte {
+	native := nl.NativeEndian()
+	b := make([]byte, sizeofVfVlanInfo)
+	native.PutUint32(b[0:4], msg.Vf)
+	native.PutUint32(b[4:8], msg.Vlan)
+	native.PutUint32(b[8:12], msg.Qos)
+	binary.BigEndian.PutUint16(b[12:14], msg.VlanProto)
+	return b
+}
+
+// VfState holds the VF settings kept by the PF driver
+type VfState struct {
+	ID        int
+	MAC       net.HardwareAddr
+	Vlan      int
+	Qos       int
+	VlanProto string
+	MinTxRate int
+	MaxTxRate int
+	Spoofchk  bool
+	Trust     bool
+	LinkState int
+}
+
+// VF link states as set with `ip link set $pf vf $vf state`
+const (
+	VfLinkStateAuto    = nl.IFLA_VF_LINK_STATE_AUTO
+	VfLinkStateEnable  = nl.IFLA_VF_LINK_STATE_ENABLE
+	VfLinkStateDisable = nl.IFLA_VF_LINK_STATE_DISABLE
+)
+
+// VfLinkStates maps the VF link state names to their netlink values
+var VfLinkStates = map[string]int{
+	"auto":    VfLinkStateAuto,
+	"enable":  VfLinkStateEnable,
+	"disable": VfLinkStateDisable,
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// setVfAttr sets the IFLA_VF_INFO attribute attrType of a VF of pfName
+func setVfAttr(pfName string, attrType int, data []byte) error {
+	return setVfInfo(pfName, func(info *nl.RtAttr) {
+		nl.NewRtAttrChild(info, attrType, data)
+	})
+}
+
+// setVfInfo sets the IFLA_VF_INFO attributes added by fill for a VF of pfName
+func setVfInfo(pfName string, fill func(info *nl.RtAttr)) error {
+	pfLink, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup PF %q: %v", pfName, err)
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(pfLink.Attrs().Index)
+	req.AddData(msg)
+
+	vfInfoList := nl.NewRtAttr(nl.IFLA_VFINFO_LIST, nil)
+	info := nl.NewRtAttrChild(vfInfoList, nl.IFLA_VF_INFO, nil)
+	fill(info)
+	req.AddData(vfInfoList)
+
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// SetVfSpoofchk turns the spoof check of the VF vfID of pfName on or off
+// Equivalent to: `ip link set $pf vf $vf spoofchk on|off`
+func SetVfSpoofchk(pfName string, vfID int, on bool) error {
+	msg := nl.VfSpoofchk{Vf: uint32(vfID), Setting: boolToUint32(on)}
+	if err := setVfAttr(pfName, nl.IFLA_VF_SPOOFCHK, msg.Serialize()); err != nil {
+		return fmt.Errorf("failed to set spoofchk of vf %d of %q: %v", vfID, pfName, err)
+	}
+	return nil
+}
+
+// SetVfTrust turns the trust mode of the VF vfID of pfName on or off
+// Equivalent to: `ip link set $pf vf $vf trust on|off`
+func SetVfTrust(pfName string, vfID int, on bool) error {
+	msg := VfTrust{Vf: uint32(vfID), Setting: boolToUint32(on)}
+	if err := setVfAttr(pfName, iflaVfTrust, msg.Serialize()); err != nil {
+		return fmt.Errorf("failed to set trust of vf %d of %q: %v", vfID, pfName, err)
+	}
+	return nil
+}
+
+// SetVfVlan sets the VLAN, its priority and its protocol (one of VlanProtos,
+// 802.1Q if empty) of the VF vfID of pfName, vlan 0 removes the VLAN
+// Equivalent to: `ip link set $pf vf $vf vlan $vlan qos $qos proto $proto`
+func SetVfVlan(pfName string, vfID int, vlan, qos int, proto string) error {
+	if proto == "" {
+		proto = VlanProto8021Q
+	}
+	ethType, ok := VlanProtos[proto]
+	if !ok {
+		return fmt.Errorf("unknown vlan protocol %q", proto)
+	}
+
+	if ethType == ethPVlan8021Q {
+		// the legacy attribute is understood by every kernel
+		msg := nl.VfVlan{Vf: uint32(vfID), Vlan: uint32(vlan), Qos: uint32(qos)}
+		if err := setVfAttr(pfName, nl.IFLA_VF_VLAN, msg.Serialize()); err != nil {
+			return fmt.Errorf("failed to set vlan %d qos %d of vf %d of %q: %v", vlan, qos, vfID, pfName, err)
+		}
+		return nil
+	}
+
+	msg := VfVlanInfo{Vf: uint32(vfID), Vlan: uint32(vlan), Qos: uint32(qos), VlanProto: ethType}
+	err := setVfInfo(pfName, func(info *nl.RtAttr) {
+		vlanList := nl.NewRtAttrChild(info, iflaVfVlanList, nil)
+		nl.NewRtAttrChild(vlanList, iflaVfVlanInfo, msg.Serialize())
+	})
+	if err == syscall.EPROTONOSUPPORT {
+		return fmt.Errorf("the driver of %q does not support vlan protocol %s for vf %d", pfName, proto, vfID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set vlan %d qos %d proto %s of vf %d of %q: %v", vlan, qos, proto, vfID, pfName, err)
+	}
+
+	// kernels without VLAN list support ignore the attribute silently
+	state, err := GetVfState(pfName, vfID)
+	if err != nil {
+		return err
+	}
+	if state.VlanProto != proto || state.Vlan != vlan {
+		return fmt.Errorf("the kernel did not apply vlan %d proto %s to vf %d of %q", vlan, proto, vfID, pfName)
+	}
+	return nil
+}
+
+// SetVfRate sets the minimum and maximum transmit rates in Mbps of the VF
+// vfID of pfName, 0 stands for no limit
+// Equivalent to: `ip link set $pf vf $vf min_tx_rate $min max_tx_rate $max`
+func SetVfRate(pfName string, vfID int, minTxRate, maxTxRate int) error {
+	msg := nl.VfRate{Vf: uint32(vfID), MinTxRate: uint32(minTxRate), MaxTxRate: uint32(maxTxRate)}
+	if err := setVfAttr(pfName, nl.IFLA_VF_RATE, msg.Serialize()); err != nil {
+		return fmt.Errorf("failed to set tx rate of vf %d of %q: %v", vfID, pfName, err)
+	}
+	return nil
+}
+
+// SetVfLinkState sets the link state of the VF vfID of pfName to one of the
+// VfLinkState values
+// Equivalent to: `ip link set $pf vf $vf state auto|enable|disable`
+func SetVfLinkState(pfName string, vfID int, linkState int) error {
+	msg := nl.VfLinkState{Vf: uint32(vfID), LinkState: uint32(linkState)}
+	if err := setVfAttr(pfName, nl.IFLA_VF_LINK_STATE, msg.Serialize()); err != nil {
+		return fmt.Errorf("failed to set link state of vf %d of %q: %v", vfID, pfName, err)
+	}
+	return nil
+}
+
+// GetVfState returns the settings of the VF vfID as reported by its PF pfName
+func GetVfState(pfName string, vfID int) (*VfState, error) {
+	pfLink, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup PF %q: %v", pfName, err)
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(pfLink.Attrs().Index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(nl.IFLA_EXT_MASK, nl.Uint32Attr(rtextFilterVf)))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the VF list of PF %q: %v", pfName, err)
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no link message received for PF %q", pfName)
+	}
+
+	states, err := parseVfInfoList(msgs[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the VF list of PF %q: %v", pfName, err)
+	}
+
+	for _, state := range states {
+		if state.ID == vfID {
+			return state, nil
+		}
+	}
+
+	return nil, fmt.Errorf("VF %d not found on PF %q", vfID, pfName)
+}
+
+func parseVfInfoList(m []byte) ([]*VfState, error) {
+	attrs, err := nl.ParseRouteAttr(m[syscall.SizeofIfInfomsg:])
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*VfState, 0)
+	for _, attr := range attrs {
+		if attr.Attr.Type&nlaTypeMask != nl.IFLA_VFINFO_LIST {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.Attr.Type&nlaTypeMask != nl.IFLA_VF_INFO {
+				continue
+			}
+			state, err := parseVfInfo(info.Value)
+			if err != nil {
+				return nil, err
+			}
+			states = append(states, state)
+		}
+	}
+
+	return states, nil
+}
+
+func parseVfInfo(b []byte) (*VfState, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+
+	native := nl.NativeEndian()
+	state := &VfState{}
+	for _, attr := range attrs {
+		switch attr.Attr.Type & nlaTypeMask {
+		case nl.IFLA_VF_MAC:
+			vfMac := nl.DeserializeVfMac(attr.Value)
+			state.ID = int(vfMac.Vf)
+			state.MAC = net.HardwareAddr(append([]byte(nil), vfMac.Mac[0:6]...))
+		case nl.IFLA_VF_VLAN:
+			vfVlan := nl.DeserializeVfVlan(attr.Value)
+			state.Vlan = int(vfVlan.Vlan)
+			state.Qos = int(vfVlan.Qos)
+		case iflaVfVlanList:
+			if err := parseVfVlanList(state, attr.Value); err != nil {
+				return nil, err
+			}
+		case nl.IFLA_VF_RATE:
+			vfRate := nl.DeserializeVfRate(attr.Value)
+			state.MinTxRate = int(vfRate.MinTxRate)
+			state.MaxTxRate = int(vfRate.MaxTxRate)
+		case nl.IFLA_VF_SPOOFCHK:
+			vfSpoofchk := nl.DeserializeVfSpoofchk(attr.Value)
+			state.Spoofchk = vfSpoofchk.Setting != 0
+		case nl.IFLA_VF_LINK_STATE:
+			vfLinkState := nl.DeserializeVfLinkState(attr.Value)
+			state.LinkState = int(vfLinkState.LinkState)
+		case iflaVfTrust:
+			if len(attr.Value) >= sizeofVfTrust {
+				state.Trust = native.Uint32(attr.Value[4:8]) != 0
+			}
+		}
+	}
+
+	return state, nil
+}
+
+// parseVfVlanList reads the protocol of the outer VLAN of a VF from its
+// IFLA_VF_VLAN_LIST attribute
+func parseVfVlanList(state *VfState, b []byte) error {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		if attr.Attr.Type&nlaTypeMask != iflaVfVlanInfo || len(attr.Value) < 14 {
+			continue
+		}
+		ethType := binary.BigEndian.Uint16(attr.Value[12:14])
+		state.VlanProto = fmt.Sprintf("0x%04x", ethType)
+		for name, t := range VlanProtos {
+			if t == ethType {
+				state.VlanProto = name
+			}
+		}
+		// only the outer VLAN is configured by the plugin
+		break
+	}
+	return nil
+}
@@ -31,26 +31,62 @@ var ts = tmpSysFs{
 	dirList: []string{
 		"sys/class/net",
 		"sys/bus/pci/devices",
+		"sys/bus/pci/drivers/i40e",
+		"sys/bus/pci/drivers/i40evf",
+		"sys/bus/pci/drivers/vfio-pci",
+		"sys/bus/pci/drivers/pcieport",
+		"sys/kernel/iommu_groups/40/devices",
+		"sys/kernel/iommu_groups/41/devices",
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/enp175s0f1",
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/net/enp175s6",
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/net/enp175s7",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0/net/enp175s0f0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0/net/enp175s2",
 	},
 	fileList: map[string][]byte{
-		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_numvfs": []byte("2"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_numvfs":         []byte("2"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/enp175s0f1/speed": []byte("25000\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0/sriov_numvfs":         []byte("1"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/driver_override":      []byte("\n"),
+		"sys/bus/pci/drivers_probe":                                             []byte(""),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/vendor":               []byte("0x8086\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/device":               []byte("0x154c\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/vendor":               []byte("0x15b3\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/device":               []byte("0x1018\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0/vendor":               []byte("0x14e4\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0/device":               []byte("0x16dc\n"),
 	},
 	netSymlinks: map[string]string{
 		"sys/class/net/enp175s0f1": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/enp175s0f1",
 		"sys/class/net/enp175s6":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/net/enp175s6",
 		"sys/class/net/enp175s7":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/net/enp175s7",
+		"sys/class/net/enp175s0f0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0/net/enp175s0f0",
+		"sys/class/net/enp175s2":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0/net/enp175s2",
 	},
 	devSymlinks: map[string]string{
 		"sys/class/net/enp175s0f1/device": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1",
 		"sys/class/net/enp175s6/device":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
 		"sys/class/net/enp175s7/device":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1",
+		"sys/class/net/enp175s0f0/device": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0",
+		"sys/class/net/enp175s2/device":   "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0",
 
 		"sys/bus/pci/devices/0000:af:00.1": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1",
 		"sys/bus/pci/devices/0000:af:06.0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
 		"sys/bus/pci/devices/0000:af:06.1": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1",
+		"sys/bus/pci/devices/0000:af:00.0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0",
+		"sys/bus/pci/devices/0000:af:02.0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0",
+		"sys/bus/pci/devices/0000:ae:00.0": "sys/devices/pci0000:ae/0000:ae:00.0",
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/driver": "sys/bus/pci/drivers/i40evf",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/driver": "sys/bus/pci/drivers/i40e",
+		"sys/devices/pci0000:ae/0000:ae:00.0/driver":              "sys/bus/pci/drivers/pcieport",
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/iommu_group": "sys/kernel/iommu_groups/40",
+		"sys/kernel/iommu_groups/40/devices/0000:af:06.0":              "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
+		"sys/kernel/iommu_groups/40/devices/0000:ae:00.0":              "sys/devices/pci0000:ae/0000:ae:00.0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/iommu_group": "sys/kernel/iommu_groups/41",
+		"sys/kernel/iommu_groups/41/devices/0000:af:06.1":              "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1",
+		"sys/kernel/iommu_groups/41/devices/0000:af:00.1":              "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1",
 	},
 	vfSymlinks: map[string]string{
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/virtfn0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
@@ -58,6 +94,9 @@ var ts = tmpSysFs{
 
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/virtfn1": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1",
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/physfn":  "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1",
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0/virtfn0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:02.0/physfn":  "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.0",
 	},
 }
 
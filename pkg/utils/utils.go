@@ -5,44 +5,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
-// SRIOVDevice : for supporting misc NIC types
-type SRIOVDevice struct {
-	name     string
-	vendor   string
-	device   string
-	driver   string
-	dpdkbind bool
-}
-
 var (
-	// SRIOVDeviceList : list of SRIOV devices supported
-	SRIOVDeviceList = []SRIOVDevice{
-		SRIOVDevice{
-			name:     "i40e",
-			vendor:   "test",
-			device:   "test",
-			driver:   "test",
-			dpdkbind: true,
-		},
-		SRIOVDevice{
-			name:     "ConnectX-5",
-			vendor:   "0x15b3",
-			device:   "0x1017",
-			driver:   "mlx5_core",
-			dpdkbind: false,
-		},
-		SRIOVDevice{
-			name:     "ConnectX-5-VF",
-			vendor:   "0x15b3",
-			device:   "0x1018",
-			driver:   "mlx5_core",
-			dpdkbind: false,
-		},
-	}
 	sriovConfigured = "/sriov_numvfs"
 	// NetDirectory sysfs net directory
 	NetDirectory = "/sys/class/net"
@@ -50,8 +20,31 @@ var (
 	SysBusPci = "/sys/bus/pci/devices"
 	// UserspaceDrivers is a list of driver names that don't have netlink representation for their devices
 	UserspaceDrivers = []string{"vfio-pci", "uio_pci_generic", "igb_uio"}
+
+	pciAddressRe = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{2}:[0-1][0-9a-fA-F]\.[0-7]$`)
+	driverNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 )
 
+// IsValidPCIAddress checks that addr is a full PCI address such as 0000:af:06.0
+func IsValidPCIAddress(addr string) bool {
+	return pciAddressRe.MatchString(addr)
+}
+
+// IsValidDriverName checks that name can be a kernel driver name
+func IsValidDriverName(name string) bool {
+	return driverNameRe.MatchString(name)
+}
+
+// IsUserspaceDriver checks whether name is one of UserspaceDrivers
+func IsUserspaceDriver(name string) bool {
+	for _, drv := range UserspaceDrivers {
+		if name == drv {
+			return true
+		}
+	}
+	return false
+}
+
 // GetSriovNumVfs takes in a PF name(ifName) as string and returns number of VF configured as int
 func GetSriovNumVfs(ifName string) (int, error) {
 	var vfTotal int
@@ -173,6 +166,26 @@ func GetSharedPF(ifName string) (string, error) {
 	return pfName, fmt.Errorf("Shared PF not found")
 }
 
+// GetLinkSpeed returns the speed in Mbps of the link ifName, or -1 when the
+// link does not report one (e.g. it is down)
+func GetLinkSpeed(ifName string) (int, error) {
+	speedFile := filepath.Join(NetDirectory, ifName, "speed")
+	data, err := ioutil.ReadFile(speedFile)
+	if err != nil {
+		// reading the speed of a link without carrier fails with EINVAL
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EINVAL {
+			return -1, nil
+		}
+		return -1, fmt.Errorf("failed to read the speed of %q: %v", ifName, err)
+	}
+
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse the speed of %q: %v", ifName, err)
+	}
+	return speed, nil
+}
+
 // ShouldHaveNetlink determines whether VF is expected to have a netlink interface
 func ShouldHaveNetlink(pfName string, vfID int) (bool, error) {
 	driverLink := filepath.Join(NetDirectory, pfName, "device", fmt.Sprintf("virtfn%d", vfID), "driver")
@@ -193,6 +206,43 @@ func ShouldHaveNetlink(pfName string, vfID int) (bool, error) {
 	return true, nil
 }
 
+// GetDriverName returns the name of the driver the PCI device pciAddr is bound to
+func GetDriverName(pciAddr string) (string, error) {
+	driverLink := filepath.Join(SysBusPci, pciAddr, "driver")
+	driverPath, err := filepath.EvalSymlinks(driverLink)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the driver of device %q: %v", pciAddr, err)
+	}
+	return filepath.Base(driverPath), nil
+}
+
+// vfReadyPollInterval is the sysfs polling period of WaitVFReady
+var vfReadyPollInterval = 50 * time.Millisecond
+
+// WaitVFReady waits until the device pciAddr is bound to driver and, unless
+// driver is a userspace driver, its netdev is registered, so that the PF
+// accepts settings for the VF again. It fails once timeout expires.
+func WaitVFReady(pciAddr, driver string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		bound, err := GetDriverName(pciAddr)
+		if err == nil && bound == driver {
+			if IsUserspaceDriver(driver) {
+				return nil
+			}
+			netdevs, err := ioutil.ReadDir(filepath.Join(SysBusPci, pciAddr, "net"))
+			if err == nil && len(netdevs) > 0 {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device %q not ready with driver %q after %v", pciAddr, driver, timeout)
+		}
+		time.Sleep(vfReadyPollInterval)
+	}
+}
+
 // GetVFLinkNames returns VF's network interface name given it's PF name as string and VF id as int
 func GetVFLinkNames(pfName string, vfID int) ([]string, error) {
 	var names []string
@@ -214,25 +264,27 @@ func GetVFLinkNames(pfName string, vfID int) ([]string, error) {
 	return names, nil
 }
 
-// GetDPDKbind returns for VF the net driver and if dpdkbind is required
-func GetDPDKbind(addr string, pfName string, vfID int) (bool, string, error) {
-	driverLink := filepath.Join(NetDirectory, pfName, "device", fmt.Sprintf("virtfn%d", vfID), "driver")
-	driverPath, err := filepath.EvalSymlinks(driverLink)
+// WriteFileAtomic writes data to path through a temporary file renamed over
+// path, so that readers see either the previous or the new content
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
 	if err != nil {
-		return false, "", err
+		return err
 	}
-	driverStat, err := os.Stat(driverPath)
-	if err != nil {
-		return false, "", err
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
 	}
-	driverName := driverStat.Name()
-	for _, sriovDevice := range SRIOVDeviceList {
-		if driverName == sriovDevice.driver {
-			return sriovDevice.dpdkbind, sriovDevice.driver, nil
-		}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
 	}
-	// for now return true and no error
-	// after filling SRIOVDeviceList with correct i40e device/driver info
-	// this should return false and error
-	return true, "", nil
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
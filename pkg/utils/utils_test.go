@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink/nl"
 )
 
 var _ = Describe("Utils", func() {
@@ -55,6 +58,58 @@ var _ = Describe("Utils", func() {
 			Expect(err).To(HaveOccurred(), "Not existing VF id should return an error")
 		})
 	})
+	Context("Checking IsValidPCIAddress function", func() {
+		It("Assuming full pci address", func() {
+			Expect(IsValidPCIAddress("0000:af:06.0")).To(BeTrue())
+		})
+		It("Assuming pci address without domain", func() {
+			Expect(IsValidPCIAddress("af:06.0")).To(BeFalse())
+		})
+		It("Assuming pci address with invalid function", func() {
+			Expect(IsValidPCIAddress("0000:af:06.8")).To(BeFalse())
+		})
+	})
+	Context("Checking GetDriverName function", func() {
+		It("Assuming device bound to a driver", func() {
+			Expect(GetDriverName("0000:af:06.0")).To(Equal("i40evf"), "Bound device should return its driver name")
+		})
+		It("Assuming device not bound to a driver", func() {
+			_, err := GetDriverName("0000:af:06.1")
+			Expect(err).To(HaveOccurred(), "Unbound device should return an error")
+		})
+	})
+	Context("Checking GetLinkSpeed function", func() {
+		It("Assuming existing interface", func() {
+			Expect(GetLinkSpeed("enp175s0f1")).To(Equal(25000), "Link speed should be read from sysfs")
+		})
+		It("Assuming not existing interface", func() {
+			_, err := GetLinkSpeed("enp175s0f2")
+			Expect(err).To(HaveOccurred(), "Not existing interface should return an error")
+		})
+	})
+	Context("Checking parseVfVlanList function", func() {
+		It("Assuming 802.1ad VLAN", func() {
+			msg := VfVlanInfo{Vf: 1, Vlan: 100, Qos: 5, VlanProto: VlanProtos[VlanProto8021AD]}
+			state := &VfState{}
+			Expect(parseVfVlanList(state, nl.NewRtAttr(iflaVfVlanInfo, msg.Serialize()).Serialize())).To(Succeed())
+			Expect(state.VlanProto).To(Equal(VlanProto8021AD))
+		})
+		It("Assuming unknown protocol", func() {
+			msg := VfVlanInfo{Vf: 1, Vlan: 100, VlanProto: 0x9100}
+			state := &VfState{}
+			Expect(parseVfVlanList(state, nl.NewRtAttr(iflaVfVlanInfo, msg.Serialize()).Serialize())).To(Succeed())
+			Expect(state.VlanProto).To(Equal("0x9100"))
+		})
+	})
+	Context("Checking WaitVFReady function", func() {
+		It("Assuming device bound with its netdev", func() {
+			Expect(WaitVFReady("0000:af:06.0", "i40evf", time.Second)).To(Succeed())
+		})
+		It("Assuming device not bound to the driver", func() {
+			err := WaitVFReady("0000:af:06.1", "i40evf", 100*time.Millisecond)
+			Expect(err).To(HaveOccurred(), "Device never bound should time out")
+		})
+	})
 	Context("Checking GetSharedPF function", func() {
 		/* TO-DO */
 		// It("Assuming existing interface", func() {
@@ -0,0 +1,75 @@
+package vfdriver
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/devicedb"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// intelResetTimeout bounds the retries of the PF requests refused while
+	// the VF resets
+	intelResetTimeout = 2 * time.Second
+	// intelRetryInterval is the period of those retries
+	intelRetryInterval = 100 * time.Millisecond
+)
+
+// intel drives the VFs of the i40e, ixgbe and ice PF drivers. These PF drivers
+// reset the VF when its MAC or VLAN changes, or when the VF driver is bound,
+// and refuse further requests with EAGAIN until the VF reset completes.
+type intel struct {
+	generic
+}
+
+func newIntel(dev devicedb.Device) VFDriver {
+	return &intel{generic{dev: dev}}
+}
+
+// retryVfReset runs fn until it succeeds, fails with an error other than
+// EAGAIN or intelResetTimeout expires
+func retryVfReset(fn func() error) error {
+	deadline := time.Now().Add(intelResetTimeout)
+	for {
+		err := fn()
+		if err != syscall.EAGAIN || time.Now().After(deadline) {
+			return err
+		}
+		logging.Debugf("retryVfReset VF still in reset, retrying")
+		time.Sleep(intelRetryInterval)
+	}
+}
+
+func (d *intel) SetMAC(pfName string, vfID int, hwaddr net.HardwareAddr) error {
+	pfLink, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", pfName, err)
+	}
+	err = retryVfReset(func() error {
+		return netlink.LinkSetVfHardwareAddr(pfLink, vfID, hwaddr)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set mac %s of vf %d: %v", hwaddr, vfID, err)
+	}
+	return nil
+}
+
+func (d *intel) ResetVlan(pfName string, vfID int) error {
+	pfLink, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", pfName, err)
+	}
+	// VLAN 0 through the legacy attribute also resets the priority and
+	// the protocol
+	err = retryVfReset(func() error {
+		return netlink.LinkSetVfVlan(pfLink, vfID, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset vlan tag for vf %d: %v", vfID, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package vfdriver
+
+import (
+	"fmt"
+
+	"github.com/intel/sriov-cni/pkg/devicedb"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+)
+
+// mellanox drives the VFs of the mlx5_core PF driver. mlx5 is bifurcated: DPDK
+// uses the VF through mlx5_core, so the VF keeps its netdev and is never
+// bound to a userspace driver.
+type mellanox struct {
+	generic
+}
+
+func newMellanox(dev devicedb.Device) VFDriver {
+	return &mellanox{generic{dev: dev}}
+}
+
+func (d *mellanox) NeedsDPDKBind() bool {
+	return false
+}
+
+func (d *mellanox) BindDPDK(dc *dpdk.Conf) (string, error) {
+	return "", fmt.Errorf("%s VF %q is used by DPDK through its kernel driver", d.dev.Name, dc.PCIaddr)
+}
+
+func (d *mellanox) UnbindDPDK(dc *dpdk.Conf) error {
+	return fmt.Errorf("%s VF %q is used by DPDK through its kernel driver", d.dev.Name, dc.PCIaddr)
+}
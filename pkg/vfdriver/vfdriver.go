@@ -0,0 +1,120 @@
+package vfdriver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/devicedb"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/utils"
+	"github.com/vishvananda/netlink"
+)
+
+// VFDriver performs the VF operations whose details depend on the NIC family
+// of the VF
+type VFDriver interface {
+	// Device returns the device database entry of the VF
+	Device() devicedb.Device
+	// NeedsDPDKBind reports whether the VF is bound to the DPDK driver in
+	// DPDK mode, bifurcated VFs are used by DPDK through their kernel driver
+	NeedsDPDKBind() bool
+	// BindDPDK binds the VF of dc to the DPDK driver and returns the driver
+	// it was bound to before
+	BindDPDK(dc *dpdk.Conf) (string, error)
+	// UnbindDPDK binds the VF of dc back to its kernel driver and returns
+	// once the VF settings can be reset on the PF
+	UnbindDPDK(dc *dpdk.Conf) error
+	// WaitReady waits until the VF pciAddr is bound to driver and usable
+	WaitReady(pciAddr, driver string, timeout time.Duration) error
+	// SetMAC sets the MAC of the VF vfID on the PF pfName
+	SetMAC(pfName string, vfID int, hwaddr net.HardwareAddr) error
+	// ResetVlan removes the VLAN of the VF vfID on the PF pfName, which also
+	// resets its priority and protocol to 802.1Q
+	ResetVlan(pfName string, vfID int) error
+}
+
+// drivers lists the VFDriver implementations by their name in the device
+// database
+var drivers = map[string]func(devicedb.Device) VFDriver{
+	"":         newGeneric,
+	"generic":  newGeneric,
+	"intel":    newIntel,
+	"mellanox": newMellanox,
+}
+
+// ForDevice returns the VFDriver of the PCI device pciAddr, selected by its
+// device database entry
+func ForDevice(pciAddr string) (VFDriver, error) {
+	dev, err := devicedb.Lookup(pciAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	newDriver, ok := drivers[dev.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %q of the %s device %q", dev.Driver, dev.Name, pciAddr)
+	}
+	return newDriver(dev), nil
+}
+
+// generic drives the VFs through the kernel interfaces common to all NICs,
+// the other implementations build on it
+type generic struct {
+	dev devicedb.Device
+}
+
+func newGeneric(dev devicedb.Device) VFDriver {
+	return &generic{dev: dev}
+}
+
+func (d *generic) Device() devicedb.Device {
+	return d.dev
+}
+
+func (d *generic) NeedsDPDKBind() bool {
+	return d.dev.NeedsDPDKBind && !d.dev.Bifurcated
+}
+
+func (d *generic) BindDPDK(dc *dpdk.Conf) (string, error) {
+	return dpdk.Enabledpdkmode(dc, true)
+}
+
+func (d *generic) UnbindDPDK(dc *dpdk.Conf) error {
+	if _, err := dpdk.Enabledpdkmode(dc, false); err != nil {
+		return fmt.Errorf("failed to bind %q back to %q: %v", dc.PCIaddr, dc.KDriver, err)
+	}
+
+	if err := d.WaitReady(dc.PCIaddr, dc.KDriver, dc.ReadyWait()); err != nil {
+		return err
+	}
+
+	if d.dev.VlanResetDelay > 0 {
+		logging.Debugf("UnbindDPDK waiting %d ms before the VLAN reset of %s", d.dev.VlanResetDelay, dc.PCIaddr)
+		time.Sleep(time.Duration(d.dev.VlanResetDelay) * time.Millisecond)
+	}
+	return nil
+}
+
+func (d *generic) WaitReady(pciAddr, driver string, timeout time.Duration) error {
+	return utils.WaitVFReady(pciAddr, driver, timeout)
+}
+
+func (d *generic) SetMAC(pfName string, vfID int, hwaddr net.HardwareAddr) error {
+	pfLink, err := netlink.LinkByName(pfName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", pfName, err)
+	}
+	if err = netlink.LinkSetVfHardwareAddr(pfLink, vfID, hwaddr); err != nil {
+		return fmt.Errorf("failed to set mac %s of vf %d: %v", hwaddr, vfID, err)
+	}
+	return nil
+}
+
+func (d *generic) ResetVlan(pfName string, vfID int) error {
+	if err := utils.SetVfVlan(pfName, vfID, 0, 0, utils.VlanProto8021Q); err != nil {
+		return fmt.Errorf("failed to reset vlan tag for vf %d: %v", vfID, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package vfdriver
+
+import (
+	"testing"
+
+	"github.com/intel/sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestVfdriver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vfdriver Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// create test sys tree
+	Expect(utils.CreateTmpSysFs()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(utils.RemoveTmpSysFs()).To(Succeed())
+})
@@ -0,0 +1,57 @@
+package vfdriver
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/intel/sriov-cni/pkg/devicedb"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func loadDB(content string) {
+	f, err := ioutil.TempFile("", "devices")
+	Expect(err).NotTo(HaveOccurred())
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(content)
+	f.Close()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(devicedb.Load(f.Name())).To(Succeed())
+}
+
+var _ = Describe("Vfdriver", func() {
+	Context("Checking ForDevice function", func() {
+		It("Assuming Intel VF", func() {
+			drv, err := ForDevice("0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drv).To(BeAssignableToTypeOf(&intel{}))
+			Expect(drv.NeedsDPDKBind()).To(BeTrue())
+		})
+		It("Assuming Mellanox VF", func() {
+			drv, err := ForDevice("0000:af:06.1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drv).To(BeAssignableToTypeOf(&mellanox{}))
+			Expect(drv.NeedsDPDKBind()).To(BeFalse())
+			_, err = drv.BindDPDK(&dpdk.Conf{PCIaddr: "0000:af:06.1", DPDKDriver: "vfio-pci"})
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming non-existing device", func() {
+			_, err := ForDevice("0000:af:07.0")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming device database entry without driver", func() {
+			loadDB(`[{"name": "test VF", "vendor": "0x15b3", "device": "0x1018", "bifurcated": true}]`)
+			drv, err := ForDevice("0000:af:06.1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(drv).To(BeAssignableToTypeOf(&generic{}))
+			Expect(drv.Device().Name).To(Equal("test VF"))
+			Expect(drv.NeedsDPDKBind()).To(BeFalse())
+		})
+		It("Assuming device database entry with unknown driver", func() {
+			loadDB(`[{"name": "test VF", "vendor": "0x15b3", "device": "0x1018", "driver": "foo"}]`)
+			_, err := ForDevice("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/config"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/vishvananda/netlink"
+)
+
+var failOverMacModes = map[string]netlink.BondFailOverMac{
+	"none":   netlink.BOND_FAIL_OVER_MAC_NONE,
+	"active": netlink.BOND_FAIL_OVER_MAC_ACTIVE,
+	"follow": netlink.BOND_FAIL_OVER_MAC_FOLLOW,
+}
+
+func newBondLink(bondName string, conf *sriovtypes.BondConf, mtu int) *netlink.Bond {
+	// the slaves take the MTU of the bond when enslaved
+	bond := netlink.NewLinkBond(netlink.LinkAttrs{Name: bondName, MTU: mtu})
+	// the vendored netlink BondMode constants do not match the kernel
+	// values, so the mode is set from config.BondModes directly
+	bond.Mode = netlink.BondMode(config.BondModes[conf.Mode])
+	bond.Miimon = *conf.Miimon
+	if conf.XmitHashPolicy != "" {
+		bond.XmitHashPolicy = netlink.StringToBondXmitHashPolicy(conf.XmitHashPolicy)
+	}
+	if conf.LacpRate != "" {
+		bond.LacpRate = netlink.StringToBondLacpRate(conf.LacpRate)
+	}
+	if conf.FailOverMac != "" {
+		bond.FailOverMac = failOverMacModes[conf.FailOverMac]
+	}
+	return bond
+}
+
+// createBond creates the bond bondName inside the pod netns and enslaves the
+// pod interfaces given in slaves, mtu 0 keeps the default MTU
+func createBond(conf *sriovtypes.BondConf, bondName string, slaves []string, mtu int, netns ns.NetNS) (*sriovtypes.Interface, error) {
+	var iface *sriovtypes.Interface
+
+	err := withHandle(netns, func(h *netlink.Handle) error {
+		if err := h.LinkAdd(newBondLink(bondName, conf, mtu)); err != nil {
+			return fmt.Errorf("failed to create bond %q: %v", bondName, err)
+		}
+
+		bond, err := h.LinkByName(bondName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup bond %q: %v", bondName, err)
+		}
+
+		for _, slave := range slaves {
+			link, err := h.LinkByName(slave)
+			if err != nil {
+				return fmt.Errorf("failed to lookup bond slave %q: %v", slave, err)
+			}
+			// a link must be down to be enslaved
+			if err = h.LinkSetDown(link); err != nil {
+				return fmt.Errorf("failed to down bond slave %q: %v", slave, err)
+			}
+			if err = h.LinkSetMasterByIndex(link, bond.Attrs().Index); err != nil {
+				return fmt.Errorf("failed to enslave %q to bond %q: %v", slave, bondName, err)
+			}
+			if err = h.LinkSetUp(link); err != nil {
+				return fmt.Errorf("failed to set up bond slave %q: %v", slave, err)
+			}
+		}
+
+		if err = h.LinkSetUp(bond); err != nil {
+			return fmt.Errorf("failed to set up bond %q: %v", bondName, err)
+		}
+
+		// reload the bond to get the MAC inherited from its slaves
+		bond, err = h.LinkByName(bondName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup bond %q: %v", bondName, err)
+		}
+
+		iface = &sriovtypes.Interface{
+			Name:    bondName,
+			Mac:     bond.Attrs().HardwareAddr.String(),
+			Sandbox: netns.Path(),
+		}
+		return nil
+	})
+	if err != nil {
+		deleteBond(bondName, netns)
+		return nil, err
+	}
+
+	logging.Debugf("createBond bond %s slaves %v mode %s", bondName, slaves, conf.Mode)
+	return iface, nil
+}
+
+// deleteBond removes the bond bondName from the pod netns, releasing its
+// slaves. A missing bond is not an error.
+func deleteBond(bondName string, netns ns.NetNS) error {
+	return withHandle(netns, func(h *netlink.Handle) error {
+		bond, err := h.LinkByName(bondName)
+		if err != nil {
+			logging.Debugf("deleteBond bond %s not found: %v", bondName, err)
+			return nil
+		}
+		if _, ok := bond.(*netlink.Bond); !ok {
+			return fmt.Errorf("link %q is not a bond", bondName)
+		}
+
+		if err = h.LinkDel(bond); err != nil {
+			return fmt.Errorf("failed to delete bond %q: %v", bondName, err)
+		}
+
+		logging.Debugf("deleteBond bond %s deleted", bondName)
+		return nil
+	})
+}
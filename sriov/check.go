@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/allocator"
+	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/state"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+	"github.com/vishvananda/netlink"
+)
+
+// checkCNIVersions lists the config versions CHECK is defined for
+var checkCNIVersions = []string{"0.4.0"}
+
+func checkError(format string, args ...interface{}) error {
+	return utils.NewError(utils.ErrCheckFailed, "", format, args...)
+}
+
+// checkPluginMain dispatches the CHECK command, which the vendored skel
+// package predates
+func checkPluginMain() {
+	stdin, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		dieErr(utils.NewError(types.ErrUnknown, "", "error reading from stdin: %v", err))
+	}
+
+	args := &skel.CmdArgs{
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+		Args:        os.Getenv("CNI_ARGS"),
+		Path:        os.Getenv("CNI_PATH"),
+		StdinData:   stdin,
+	}
+	for name, val := range map[string]string{"CNI_CONTAINERID": args.ContainerID, "CNI_NETNS": args.Netns, "CNI_IFNAME": args.IfName, "CNI_PATH": args.Path} {
+		if val == "" {
+			dieErr(utils.NewError(utils.ErrInvalidEnvironmentVariables, "", "%v env variable missing", name))
+		}
+	}
+
+	var conf struct {
+		CNIVersion string `json:"cniVersion"`
+	}
+	if err = json.Unmarshal(stdin, &conf); err != nil {
+		dieErr(utils.NewError(utils.ErrDecodingFailure, "", "decoding version from network config: %v", err))
+	}
+	supported := false
+	for _, v := range checkCNIVersions {
+		if conf.CNIVersion == v {
+			supported = true
+		}
+	}
+	if !supported {
+		dieErr(utils.NewError(types.ErrIncompatibleCNIVersion, fmt.Sprintf("config is %q, CHECK requires one of %v", conf.CNIVersion, checkCNIVersions), "incompatible CNI versions"))
+	}
+
+	if err = cmdCheck(args); err != nil {
+		if e, ok := err.(*types.Error); ok {
+			dieErr(e)
+		}
+		dieErr(utils.NewError(types.ErrUnknown, "", "%v", err))
+	}
+}
+
+func dieErr(e *types.Error) {
+	if err := e.Print(); err != nil {
+		logging.Debugf("error writing error JSON to stdout: %v", err)
+	}
+	os.Exit(1)
+}
+
+// cmdCheck verifies that the attachment still matches the state recorded by
+// ADD and the prevResult it returned. The VFs are the ones recorded by ADD,
+// they are not resolved again from the configuration.
+func cmdCheck(args *skel.CmdArgs) error {
+	n, err := config.ParseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if n.PrevResult == nil {
+		return utils.NewConfError(utils.ErrInvalidNetworkConfig, "required prevResult missing")
+	}
+
+	st, err := state.Load(n.CNIDir, args.ContainerID, args.IfName)
+	if err == state.ErrNotFound {
+		return checkError("no state recorded for container %q ifname %q", args.ContainerID, args.IfName)
+	}
+	if err != nil {
+		return err
+	}
+
+	if n.VlanSelection == config.VlanSelectionPool {
+		if err = checkVlanLease(n.CNIDir, n.Name, st); err != nil {
+			return err
+		}
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	for _, vf := range st.VFs {
+		if err = checkVF(vf, n.PrevResult, netns); err != nil {
+			return err
+		}
+	}
+
+	if st.Bond != nil {
+		if err = checkBond(st.Bond.Name, st.Bond.Slaves, netns); err != nil {
+			return err
+		}
+	}
+
+	if err = checkIPs(n.PrevResult, netns); err != nil {
+		return err
+	}
+
+	logging.Debugf("cmdCheck success cid %s ifname %s", args.ContainerID, args.IfName)
+	return nil
+}
+
+// checkVlanLease verifies that the pool VLANs of the VFs of st are still
+// leased to the attachment on the network network
+func checkVlanLease(dataDir, network string, st *state.State) error {
+	alloc, err := allocator.Open(dataDir)
+	if err != nil {
+		return err
+	}
+	defer alloc.Close()
+
+	for _, vf := range st.VFs {
+		owner, ok := alloc.VlanOwner(network, vf.Vlan)
+		if !ok || owner.ContainerID != st.ContainerID || owner.IfName != st.IfName {
+			return checkError("vlan %d of VF %d of PF %q is not leased to the attachment", vf.Vlan, vf.VFID, vf.PFName)
+		}
+	}
+	return nil
+}
+
+func prevInterface(prevResult *sriovtypes.Result, ifname string) *sriovtypes.Interface {
+	for _, iface := range prevResult.Interfaces {
+		if iface.Name == ifname {
+			return iface
+		}
+	}
+	return nil
+}
+
+// checkVF verifies that the VF recorded by ADD is still the VF of its PF, its
+// settings on the PF and, unless it is bound to a userspace driver, the VF
+// netdev in the pod netns
+func checkVF(vf *state.VF, prevResult *sriovtypes.Result, netns ns.NetNS) error {
+	pciAddr, err := utils.GetPciAddress(vf.PFName, vf.VFID)
+	if err != nil {
+		return checkError("VF %d of PF %q not found: %v", vf.VFID, vf.PFName, err)
+	}
+	if pciAddr != vf.PCIAddr {
+		return checkError("VF %d of PF %q is %q instead of %q", vf.VFID, vf.PFName, pciAddr, vf.PCIAddr)
+	}
+
+	if err := checkVfState(vf); err != nil {
+		return err
+	}
+
+	userspaceDriver := ""
+	if vf.DPDKBound {
+		userspaceDriver = vf.DPDKConf.DPDKDriver
+	} else if vf.DPDKConf != nil && utils.IsUserspaceDriver(vf.Orig.Driver) {
+		// handed to the pod on the userspace driver found at ADD
+		userspaceDriver = vf.Orig.Driver
+	}
+	if userspaceDriver != "" {
+		driver, err := utils.GetDriverName(vf.PCIAddr)
+		if err != nil {
+			return checkError("VF %q is not bound to any driver: %v", vf.PCIAddr, err)
+		}
+		if driver != userspaceDriver {
+			return checkError("VF %q is bound to %q instead of %q", vf.PCIAddr, driver, userspaceDriver)
+		}
+		if vf.IOMMUGroup != "" {
+			if _, err := os.Stat(dpdk.GroupPath(vf.IOMMUGroup)); err != nil {
+				return checkError("vfio group of VF %q not found: %v", vf.PCIAddr, err)
+			}
+		}
+		return nil
+	}
+
+	return checkPodLink(vf.PodIfName, prevInterface(prevResult, vf.PodIfName), vf.MTU, netns)
+}
+
+// checkVfState verifies the VF settings held by the PF
+func checkVfState(vf *state.VF) error {
+	vfState, err := utils.GetVfState(vf.PFName, vf.VFID)
+	if err != nil {
+		return fmt.Errorf("failed to read VF %d state of PF %q: %v", vf.VFID, vf.PFName, err)
+	}
+	return compareVfState(vf, vfState)
+}
+
+// compareVfState verifies that the VF settings vfState reported by the PF are
+// the ones set by ADD, the settings ADD left alone are not checked
+func compareVfState(vf *state.VF, vfState *utils.VfState) error {
+	if vf.MAC != "" && vfState.MAC.String() != vf.MAC {
+		return checkError("VF %d of PF %q has mac %s instead of %s", vf.VFID, vf.PFName, vfState.MAC, vf.MAC)
+	}
+	// without a VLAN from ADD the VF keeps the one it had before
+	vlan := vf.Vlan
+	if vlan == 0 {
+		vlan = vf.Orig.Vlan
+	}
+	if vfState.Vlan != vlan {
+		return checkError("VF %d of PF %q has vlan %d instead of %d", vf.VFID, vf.PFName, vfState.Vlan, vlan)
+	}
+	if vf.Vlan != 0 {
+		if vfState.Qos != vf.VlanQoS {
+			return checkError("VF %d of PF %q has vlan qos %d instead of %d", vf.VFID, vf.PFName, vfState.Qos, vf.VlanQoS)
+		}
+		proto := vf.VlanProto
+		if proto == "" {
+			proto = utils.VlanProto8021Q
+		}
+		// kernels without VLAN list support do not report the protocol
+		if vfState.VlanProto != "" && vfState.VlanProto != proto {
+			return checkError("VF %d of PF %q has vlan protocol %s instead of %s", vf.VFID, vf.PFName, vfState.VlanProto, proto)
+		}
+	}
+	if vf.SpoofChk != "" && vfState.Spoofchk != (vf.SpoofChk == "on") {
+		return checkError("VF %d of PF %q spoofchk is not %s", vf.VFID, vf.PFName, vf.SpoofChk)
+	}
+	if vf.Trust != "" && vfState.Trust != (vf.Trust == "on") {
+		return checkError("VF %d of PF %q trust is not %s", vf.VFID, vf.PFName, vf.Trust)
+	}
+	if vf.LinkState != "" {
+		if vfState.LinkState != utils.VfLinkStates[vf.LinkState] {
+			return checkError("VF %d of PF %q link state is not %s", vf.VFID, vf.PFName, vf.LinkState)
+		}
+	} else if vfState.LinkState == utils.VfLinkStateDisable {
+		return checkError("VF %d of PF %q link state is disabled", vf.VFID, vf.PFName)
+	}
+	if (vf.MinTxRate != 0 || vf.MaxTxRate != 0) && (vfState.MinTxRate != vf.MinTxRate || vfState.MaxTxRate != vf.MaxTxRate) {
+		return checkError("VF %d of PF %q has tx rates %d-%d Mbps instead of %d-%d Mbps", vf.VFID, vf.PFName, vfState.MinTxRate, vfState.MaxTxRate, vf.MinTxRate, vf.MaxTxRate)
+	}
+
+	return nil
+}
+
+// checkPodLink verifies that ifname is in the pod netns with the MAC of iface
+// and, unless it is 0, the MTU mtu
+func checkPodLink(ifname string, iface *sriovtypes.Interface, mtu int, netns ns.NetNS) error {
+	return withHandle(netns, func(h *netlink.Handle) error {
+		link, err := h.LinkByName(ifname)
+		if err != nil {
+			return checkError("pod interface %q not found in netns %q: %v", ifname, netns.Path(), err)
+		}
+		if iface != nil && iface.Mac != "" && link.Attrs().HardwareAddr.String() != iface.Mac {
+			return checkError("pod interface %q has mac %s instead of %s", ifname, link.Attrs().HardwareAddr, iface.Mac)
+		}
+		if mtu != 0 && link.Attrs().MTU != mtu {
+			return checkError("pod interface %q has mtu %d instead of %d", ifname, link.Attrs().MTU, mtu)
+		}
+		return nil
+	})
+}
+
+// checkBond verifies that the bond exists in the pod netns with its slaves
+func checkBond(bondName string, slaves []string, netns ns.NetNS) error {
+	return withHandle(netns, func(h *netlink.Handle) error {
+		bond, err := h.LinkByName(bondName)
+		if err != nil {
+			return checkError("bond %q not found in netns %q: %v", bondName, netns.Path(), err)
+		}
+		if _, ok := bond.(*netlink.Bond); !ok {
+			return checkError("pod interface %q is not a bond", bondName)
+		}
+		for _, slave := range slaves {
+			link, err := h.LinkByName(slave)
+			if err != nil {
+				return checkError("bond slave %q not found in netns %q: %v", slave, netns.Path(), err)
+			}
+			if link.Attrs().MasterIndex != bond.Attrs().Index {
+				return checkError("interface %q is not enslaved to bond %q", slave, bondName)
+			}
+		}
+		return nil
+	})
+}
+
+// checkIPs verifies that the IPAM addresses of prevResult are still set
+func checkIPs(prevResult *sriovtypes.Result, netns ns.NetNS) error {
+	return withHandle(netns, func(h *netlink.Handle) error {
+		for _, ipc := range prevResult.IPs {
+			if ipc.Interface == nil || *ipc.Interface < 0 || *ipc.Interface >= len(prevResult.Interfaces) {
+				continue
+			}
+			ifname := prevResult.Interfaces[*ipc.Interface].Name
+
+			link, err := h.LinkByName(ifname)
+			if err != nil {
+				return checkError("pod interface %q not found in netns %q: %v", ifname, netns.Path(), err)
+			}
+			addrs, err := h.AddrList(link, netlink.FAMILY_ALL)
+			if err != nil {
+				return fmt.Errorf("failed to list addresses of %q: %v", ifname, err)
+			}
+
+			want := (*net.IPNet)(&ipc.Address).String()
+			found := false
+			for _, addr := range addrs {
+				if addr.IPNet.String() == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return checkError("address %s not found on pod interface %q", want, ifname)
+			}
+		}
+		return nil
+	})
+}
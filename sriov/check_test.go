@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/state"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+func expectCheckFailed(err error) {
+	Expect(err).To(HaveOccurred())
+	Expect(err.(*types.Error).Code).To(Equal(utils.ErrCheckFailed))
+}
+
+var _ = Describe("Check", func() {
+	Context("Checking compareVfState function", func() {
+		mac, _ := net.ParseMAC("66:77:88:99:aa:bb")
+		var vf *state.VF
+		var vfState *utils.VfState
+		BeforeEach(func() {
+			vf = &state.VF{PFName: "enp175s0f0", VFID: 0}
+			vfState = &utils.VfState{MAC: mac, LinkState: utils.VfLinkStateAuto}
+		})
+		It("Assuming vlan set by ADD", func() {
+			vf.Vlan = 100
+			vfState.Vlan = 100
+			Expect(compareVfState(vf, vfState)).To(Succeed())
+		})
+		It("Assuming vlan changed since ADD", func() {
+			vf.Vlan = 100
+			vfState.Vlan = 200
+			expectCheckFailed(compareVfState(vf, vfState))
+		})
+		It("Assuming vlan of the VF left by ADD", func() {
+			vf.Orig.Vlan = 300
+			vfState.Vlan = 300
+			Expect(compareVfState(vf, vfState)).To(Succeed(), "The VLAN the VF had before ADD should not fail CHECK")
+		})
+		It("Assuming mac set by ADD", func() {
+			vf.MAC = "66:77:88:99:aa:bb"
+			Expect(compareVfState(vf, vfState)).To(Succeed())
+		})
+		It("Assuming mac changed since ADD", func() {
+			vf.MAC = "66:77:88:99:aa:01"
+			expectCheckFailed(compareVfState(vf, vfState))
+		})
+	})
+	Context("Checking checkPodLink function", func() {
+		var netns ns.NetNS
+		var hwaddr string
+		BeforeEach(func() {
+			if os.Getuid() != 0 {
+				Skip("creating netns requires root")
+			}
+			var err error
+			netns, err = ns.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withHandle(netns, func(h *netlink.Handle) error {
+				if err := h.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1", MTU: 9000}, PeerName: "peer1"}); err != nil {
+					return err
+				}
+				link, err := h.LinkByName("net1")
+				if err != nil {
+					return err
+				}
+				hwaddr = link.Attrs().HardwareAddr.String()
+				return nil
+			})).To(Succeed())
+		})
+		AfterEach(func() {
+			Expect(netns.Close()).To(Succeed())
+		})
+		It("Assuming pod interface as set up by ADD", func() {
+			Expect(checkPodLink("net1", &sriovtypes.Interface{Name: "net1", Mac: hwaddr}, 9000, netns)).To(Succeed())
+		})
+		It("Assuming pod interface gone", func() {
+			expectCheckFailed(checkPodLink("net2", nil, 0, netns))
+		})
+		It("Assuming mac changed since ADD", func() {
+			expectCheckFailed(checkPodLink("net1", &sriovtypes.Interface{Name: "net1", Mac: "66:77:88:99:aa:01"}, 0, netns))
+		})
+		It("Assuming mtu changed since ADD", func() {
+			expectCheckFailed(checkPodLink("net1", nil, 1500, netns))
+		})
+	})
+	Context("Checking checkIPs function", func() {
+		var netns ns.NetNS
+		var prevResult *sriovtypes.Result
+		BeforeEach(func() {
+			if os.Getuid() != 0 {
+				Skip("creating netns requires root")
+			}
+			var err error
+			netns, err = ns.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			ipn := net.IPNet{IP: net.IPv4(10, 1, 1, 2), Mask: net.CIDRMask(24, 32)}
+			Expect(withHandle(netns, func(h *netlink.Handle) error {
+				if err := h.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}, PeerName: "peer1"}); err != nil {
+					return err
+				}
+				return configureIface(h, "net1", &types.Result{IP4: &types.IPConfig{IP: ipn}})
+			})).To(Succeed())
+
+			iface := podInterface("net1", netns)
+			Expect(iface).NotTo(BeNil())
+			prevResult = &sriovtypes.Result{Interfaces: []*sriovtypes.Interface{iface}}
+			ifIndex := 0
+			prevResult.IPs = []*sriovtypes.IPConfig{{Version: "4", Interface: &ifIndex, Address: types.IPNet(ipn)}}
+		})
+		AfterEach(func() {
+			Expect(netns.Close()).To(Succeed())
+		})
+		It("Assuming addresses set up by ADD", func() {
+			Expect(checkIPs(prevResult, netns)).To(Succeed())
+		})
+		It("Assuming address removed since ADD", func() {
+			prevResult.IPs[0].Address.IP = net.IPv4(10, 1, 1, 3)
+			expectCheckFailed(checkIPs(prevResult, netns))
+		})
+		It("Assuming pod interface gone", func() {
+			Expect(podInterface("net2", netns)).To(BeNil())
+			prevResult.Interfaces[0].Name = "net2"
+			expectCheckFailed(checkIPs(prevResult, netns))
+		})
+	})
+})
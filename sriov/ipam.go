@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	"github.com/intel/multus-cni/logging"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/vishvananda/netlink"
+)
+
+// ipamCNIVersion is the only result version the vendored CNI library can parse
+const ipamCNIVersion = "0.2.0"
+
+// ipamNetConf rewrites the cniVersion of the network configuration so that
+// the IPAM plugin answers with a result the vendored invoke package can decode
+func ipamNetConf(stdin []byte) ([]byte, error) {
+	conf := make(map[string]interface{})
+	if err := json.Unmarshal(stdin, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse netconf for IPAM: %v", err)
+	}
+	conf["cniVersion"] = ipamCNIVersion
+
+	return json.Marshal(conf)
+}
+
+func ipamArgs(command string, args *skel.CmdArgs, ifName string) *invoke.Args {
+	return &invoke.Args{
+		Command:       command,
+		ContainerID:   args.ContainerID,
+		NetNS:         args.Netns,
+		PluginArgsStr: args.Args,
+		IfName:        ifName,
+		Path:          args.Path,
+	}
+}
+
+// execIPAMAdd delegates the address allocation for the pod interface ifName
+// to the configured IPAM plugin
+func execIPAMAdd(args *skel.CmdArgs, ipamType, ifName string) (*types.Result, error) {
+	netconf, err := ipamNetConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	pluginPath, err := invoke.FindInPath(ipamType, strings.Split(args.Path, ":"))
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Debugf("execIPAMAdd plugin %s cid %s ifname %s", pluginPath, args.ContainerID, ifName)
+	return invoke.ExecPluginWithResult(pluginPath, netconf, ipamArgs("ADD", args, ifName))
+}
+
+// execIPAMDel releases the addresses allocated for the pod interface ifName
+func execIPAMDel(args *skel.CmdArgs, ipamType, ifName string) error {
+	netconf, err := ipamNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	pluginPath, err := invoke.FindInPath(ipamType, strings.Split(args.Path, ":"))
+	if err != nil {
+		return err
+	}
+
+	logging.Debugf("execIPAMDel plugin %s cid %s ifname %s", pluginPath, args.ContainerID, ifName)
+	return invoke.ExecPluginWithoutResult(pluginPath, netconf, ipamArgs("DEL", args, ifName))
+}
+
+// configureIface brings ifName up and applies the IPAM addresses and routes
+// through the handle h of the pod netns
+func configureIface(h *netlink.Handle, ifName string, res *types.Result) error {
+	link, err := h.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	if err = h.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set %q UP: %v", ifName, err)
+	}
+
+	for _, ipc := range []*types.IPConfig{res.IP4, res.IP6} {
+		if ipc == nil {
+			continue
+		}
+
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: ipc.IP.IP, Mask: ipc.IP.Mask}, Label: ""}
+		if err = h.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add IP addr %v to %q: %v", ipc.IP, ifName, err)
+		}
+
+		for _, r := range ipc.Routes {
+			gw := r.GW
+			if gw == nil {
+				gw = ipc.Gateway
+			}
+			route := &netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Scope:     netlink.SCOPE_UNIVERSE,
+				Dst:       &r.Dst,
+				Gw:        gw,
+			}
+			if err = h.RouteAdd(route); err != nil {
+				// we skip over duplicate routes as we assume the first one wins
+				if !os.IsExist(err) {
+					return fmt.Errorf("failed to add route '%v via %v dev %v': %v", r.Dst, gw, ifName, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// addVFIPAM allocates addresses for the pod interface ifName, applies them
+// inside the pod netns and records them in result against ifIndex
+func addVFIPAM(args *skel.CmdArgs, ipamType, ifName string, netns ns.NetNS, result *sriovtypes.Result, ifIndex int) error {
+	ipamResult, err := execIPAMAdd(args, ipamType, ifName)
+	if err != nil {
+		return fmt.Errorf("failed to set up IPAM plugin type %q for %q: %v", ipamType, ifName, err)
+	}
+
+	if ipamResult.IP4 == nil && ipamResult.IP6 == nil {
+		execIPAMDel(args, ipamType, ifName)
+		return fmt.Errorf("IPAM plugin %q returned missing IP config for %q", ipamType, ifName)
+	}
+
+	err = withHandle(netns, func(h *netlink.Handle) error {
+		return configureIface(h, ifName, ipamResult)
+	})
+	if err != nil {
+		execIPAMDel(args, ipamType, ifName)
+		return fmt.Errorf("failed to configure IPAM addresses on %q: %v", ifName, err)
+	}
+
+	result.AddLegacyResult(ipamResult, ifIndex)
+	logging.Debugf("addVFIPAM ifname %s result %v", ifName, ipamResult)
+	return nil
+}
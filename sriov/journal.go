@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/intel/multus-cni/logging"
+)
+
+// undoStep is the undo action of a step completed by ADD
+type undoStep struct {
+	name string
+	undo func() error
+}
+
+// journal records the undo actions of the steps completed by ADD across all
+// the VFs of the attachment, so that a failed ADD rolls them all back
+type journal struct {
+	steps []undoStep
+}
+
+// add registers undo as the undo action of the completed step name
+func (j *journal) add(name string, undo func() error) {
+	j.steps = append(j.steps, undoStep{name: name, undo: undo})
+}
+
+// rollback runs the undo actions in reverse order of their steps and empties
+// the journal. Every action is run even if an earlier one fails, the first
+// failure is returned.
+func (j *journal) rollback() error {
+	var firstErr error
+	for i := len(j.steps) - 1; i >= 0; i-- {
+		step := j.steps[i]
+		logging.Debugf("journal rollback %s", step.name)
+		if err := step.undo(); err != nil {
+			logging.Debugf("journal rollback %s failed: %v", step.name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	j.steps = nil
+	return firstErr
+}
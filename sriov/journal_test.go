@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	Context("Checking rollback function", func() {
+		It("Assuming completed steps", func() {
+			j := &journal{}
+			undone := []string{}
+			for _, name := range []string{"vlan", "mac", "move"} {
+				step := name
+				j.add(step, func() error {
+					undone = append(undone, step)
+					return nil
+				})
+			}
+			Expect(j.rollback()).To(Succeed())
+			Expect(undone).To(Equal([]string{"move", "mac", "vlan"}))
+			Expect(j.steps).To(BeEmpty())
+		})
+		It("Assuming failing undo action", func() {
+			j := &journal{}
+			undone := []string{}
+			j.add("vlan", func() error {
+				undone = append(undone, "vlan")
+				return nil
+			})
+			j.add("mac", func() error {
+				return fmt.Errorf("mac failed")
+			})
+			j.add("move", func() error {
+				return fmt.Errorf("move failed")
+			})
+			err := j.rollback()
+			Expect(err).To(MatchError("move failed"))
+			Expect(undone).To(Equal([]string{"vlan"}), "The steps before a failing one should be undone")
+		})
+	})
+})
@@ -1,21 +1,22 @@
 package main
 
 import (
-	//"errors"
 	"fmt"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
 
-	//"github.com/containernetworking/cni/pkg/ipam"
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/containernetworking/cni/pkg/skel"
-	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/version"
 	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/allocator"
 	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/state"
 	sriovtypes "github.com/intel/sriov-cni/pkg/types"
-	//"github.com/intel/sriov-cni/pkg/utils"
+	"github.com/intel/sriov-cni/pkg/utils"
 	"github.com/vishvananda/netlink"
 )
 
@@ -29,92 +30,31 @@ func init() {
 }
 
 func getPodName(args *skel.CmdArgs) string {
-	podname := ""
-	pairs := strings.Split(args.Args, ";")
-	for _, pair := range pairs {
-		kv := strings.Split(pair, "=")
-		if len(kv) != 2 {
-			return podname
-		}
-		if kv[0] == "K8S_POD_NAME" {
-			podname = kv[1]
-			break
-		}
-	}
-
-	//if len(podname) == 0 {
-	//	return 0, podname, fmt.Errorf("getVlanIndex: podname not found")
-	//}
-	return podname
-
+	return config.ParseCNIArgs(args.Args)["K8S_POD_NAME"]
 }
 
-func getVlanIndex(args *skel.CmdArgs) (int, string, error) {
-	logging.Debugf("getVlanIndex args.Args %v", args.Args)
-
-	podname := ""
-	pairs := strings.Split(args.Args, ";")
-	for _, pair := range pairs {
-		kv := strings.Split(pair, "=")
-		if len(kv) != 2 {
-			return 0, podname, fmt.Errorf("getVlanIndex: invalid pair %q", pair)
-		}
-		if kv[0] == "K8S_POD_NAME" {
-			podname = kv[1]
-			break
-		}
-	}
-
-	if len(podname) == 0 {
-		return 0, podname, fmt.Errorf("getVlanIndex: podname not found")
+// podIfName returns the pod interface name of the i-th device, unless one is
+// given for the device it is the pod ifname suffixed with the index of the
+// device. The VF assigned from the master takes the pod ifname.
+func podIfName(slave *sriovtypes.NetConf, ifName string, i int, assigned bool) string {
+	if slave.PodIfName != "" {
+		return slave.PodIfName
 	}
-
-	ss := strings.Split(podname, "-")
-	s := ss[len(ss)-1]
-
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return 0, podname, fmt.Errorf("SRIOV-CNI failed to get vlanindex podname %q: %v", podname, err)
+	if assigned {
+		return ifName
 	}
-	return i, podname, nil
+	return ifName + "-" + strconv.Itoa(i)
 }
 
-func cmdAddBondedDevice(args *skel.CmdArgs, n *sriovtypes.NetConf, i int) error {
-	netns, err := ns.GetNS(args.Netns)
+func cmdAddBondedDevice(args *skel.CmdArgs, n *sriovtypes.NetConf, vf *state.VF, ifname string, netns ns.NetNS, j *journal) error {
+	var err error
 
-	ifname := args.IfName + "-" + strconv.Itoa(i)
 	logging.Debugf("PKKK-X cmdAddBondedDevice DeviceID %s ifname %s", n.DeviceID, ifname)
 
-	// fill in DpdkConf from DeviceInfo
-	if n.DPDKMode {
-		n.DPDKConf.PCIaddr = n.DeviceInfo.PCIaddr
-		n.DPDKConf.Ifname = ifname
-		n.DPDKConf.VFID = n.DeviceInfo.Vfid
-	}
-
 	if n.DeviceInfo != nil && n.DeviceInfo.PCIaddr != "" && n.DeviceInfo.Vfid >= 0 && n.DeviceInfo.Pfname != "" {
-		err = setupVF(n, ifname, args.ContainerID, netns)
+		err = setupVF(n, vf, ifname, args.ContainerID, netns, j)
 		if err != nil {
 			logging.Debugf("PKKK-ERROR cmdAddBondedDevice DeviceID %s ifname %s", n.DeviceID, ifname)
-		}
-
-		defer func() {
-			if err != nil {
-				if !n.DPDKMode {
-					err = netns.Do(func(_ ns.NetNS) error {
-						_, err := netlink.LinkByName(ifname)
-						logging.Debugf("PKKK-EX cmdAddBondedDevice DeviceID %s ifname %s", n.DeviceID, ifname)
-						return err
-					})
-				}
-				if n.DPDKMode || err == nil {
-					logging.Debugf("PKKK-EX1 cmdAddBondedDevice DeviceID %s ifname %s", n.DeviceID, ifname)
-					releaseVF(n, ifname, args.ContainerID, netns)
-				}
-			}
-		}()
-		if err != nil {
-			logging.Debugf("PKKK-EX2 cmdAddBondedDevice DeviceID %s ifname %s", n.DeviceID, ifname)
 			return fmt.Errorf("failed to set up pod interface %q from the device %q: %v", ifname, n.Master, err)
 		}
 	} else {
@@ -125,101 +65,374 @@ func cmdAddBondedDevice(args *skel.CmdArgs, n *sriovtypes.NetConf, i int) error
 	return nil
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
-	var result *types.Result
+// podInterface returns the Result interface for the VF moved into the pod
+// as ifname, or nil if the VF has no netdev in the pod (e.g. bound to a DPDK
+// driver)
+func podInterface(ifname string, netns ns.NetNS) *sriovtypes.Interface {
+	var iface *sriovtypes.Interface
+	withHandle(netns, func(h *netlink.Handle) error {
+		link, err := h.LinkByName(ifname)
+		if err != nil {
+			return err
+		}
+		iface = &sriovtypes.Interface{
+			Name:    ifname,
+			Mac:     link.Attrs().HardwareAddr.String(),
+			Sandbox: netns.Path(),
+		}
+		return nil
+	})
+	return iface
+}
 
+func cmdAdd(args *skel.CmdArgs) error {
 	podname := getPodName(args)
 
 	n, bondedlist, err := config.LoadConf(args.StdinData)
 	if err != nil {
-		logging.Debugf("PKKK-Error podname %s ifname %s LoadConf return error", podname, args.IfName)
-		return result.Print()
-		//return fmt.Errorf("SRIOV-CNI failed to load netconf: %v", err)
+		logging.Debugf("PKKK-Error podname %s ifname %s LoadConf return error %v", podname, args.IfName, err)
+		return err
 	}
 
-	// PK - FIXME
 	logging.Debugf("PKKK-MAIN podname %s ifname %s %+v", podname, args.IfName, bondedlist)
-	//return result.Print()
-
-	if bondedlist == nil {
-		logging.Debugf("PKKK-Error podname %s ifname %s bondedlist is empty", podname, args.IfName)
-		return result.Print()
-	}
 
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
 	}
 	defer netns.Close()
 
-	if n.Vlans != nil {
-		index, podname, err := getVlanIndex(args)
+	// no deviceID given, a free VF of the master is assigned to n
+	assign := bondedlist == nil
+	if assign {
+		bondedlist = []*sriovtypes.NetConf{n}
+	}
+
+	if err = reserveVFs(args, n, bondedlist, assign); err != nil {
+		return err
+	}
+
+	// every completed step registers its undo action, a failure rolls back
+	// the steps of all the VFs
+	j := &journal{}
+	result := &sriovtypes.Result{CNIVersion: n.CNIVersion}
+	st := state.New(args.ContainerID, args.IfName, args.Netns)
+	slaves := make([]string, 0, len(bondedlist))
+	for i, slave := range bondedlist {
+		ifname := podIfName(slave, args.IfName, i, assign)
+		// fill in DpdkConf from DeviceInfo
+		if err = fillDPDKConf(slave, ifname); err != nil {
+			rollbackAdd(args, n, st, j)
+			return err
+		}
+		var vf *state.VF
+		if vf, err = newVFState(slave, ifname); err != nil {
+			rollbackAdd(args, n, st, j)
+			return err
+		}
+
+		// record the VF before touching it, so that DEL can release it
+		// should ADD be interrupted
+		st.VFs = append(st.VFs, vf)
+		if err = state.Save(n.CNIDir, st); err != nil {
+			st.VFs = st.VFs[:i]
+			rollbackAdd(args, n, st, j)
+			return err
+		}
+
+		err = cmdAddBondedDevice(args, slave, vf, ifname, netns, j)
 		if err != nil {
-			return fmt.Errorf("failed to get VLAN index for args %v err:%v", args, n.Vlans, err)
+			logging.Debugf("PKKK-B cmdAddBondedDevice failed %v", i)
+			rollbackAdd(args, n, st, j)
+			return fmt.Errorf("failed to add bonded device: %v", err)
+		}
+		slaves = append(slaves, ifname)
+
+		iface := podInterface(ifname, netns)
+		if iface == nil {
+			// DPDK bound VFs are only known by their PCI address
+			iface = &sriovtypes.Interface{Name: ifname, Sandbox: netns.Path()}
 		}
-		if index < len(n.Vlans) {
-			n.Vlan = n.Vlans[index]
-			logging.Debugf("setupVF podname : %s, podifname %s, vlan %v vlans %v", podname, args.IfName, n.Vlan, n.Vlans)
+		iface.PCIAddr = vf.PCIAddr
+		if vf.IOMMUGroup != "" {
+			iface.VfioGroup = dpdk.GroupPath(vf.IOMMUGroup)
 		}
+		ifIndex := result.AddInterface(iface)
+
+		// IPAM is not executed for L2 and DPDK VFs nor for bond slaves
+		if n.Bond != nil || slave.L2Mode || slave.DPDKMode || slave.IPAM.Type == "" {
+			continue
+		}
+		if err = addVFIPAM(args, slave.IPAM.Type, ifname, netns, result, ifIndex); err != nil {
+			rollbackAdd(args, n, st, j)
+			return err
+		}
+		ipamType := slave.IPAM.Type
+		j.add("ipam "+ifname, func() error {
+			return execIPAMDel(args, ipamType, ifname)
+		})
 	}
 
-	if bondedlist != nil {
-		for i, slave := range bondedlist {
-			err = cmdAddBondedDevice(args, slave, i)
-			if err != nil {
-				logging.Debugf("PKKK-B cmdAddBondedDevice failed %v", i)
-				return fmt.Errorf("failed to add bonded device: %v", err)
+	if n.Bond != nil {
+		var bond *sriovtypes.Interface
+		if bond, err = createBond(n.Bond, args.IfName, slaves, n.MTU, netns); err != nil {
+			rollbackAdd(args, n, st, j)
+			return err
+		}
+		j.add("bond "+args.IfName, func() error {
+			return deleteBond(args.IfName, netns)
+		})
+		// enslaving rewrites the slave MACs, report the current ones
+		for _, iface := range result.Interfaces {
+			if slaveIface := podInterface(iface.Name, netns); slaveIface != nil {
+				iface.Mac = slaveIface.Mac
+			}
+		}
+		ifIndex := result.AddInterface(bond)
+
+		if !n.L2Mode && n.IPAM.Type != "" {
+			if err = addVFIPAM(args, n.IPAM.Type, args.IfName, netns, result, ifIndex); err != nil {
+				rollbackAdd(args, n, st, j)
+				return err
 			}
+			j.add("ipam "+args.IfName, func() error {
+				return execIPAMDel(args, n.IPAM.Type, args.IfName)
+			})
 		}
+		st.Bond = &state.Bond{Name: args.IfName, Mode: n.Bond.Mode, Slaves: slaves}
+	}
+
+	if err = state.Save(n.CNIDir, st); err != nil {
+		logging.Debugf("cmdAdd state.Save failed podname %s ifname %s %v", podname, args.IfName, err)
+		rollbackAdd(args, n, st, j)
+		return err
 	}
 
-	// no error
 	return result.Print()
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-	n, bondedlist, err := config.LoadConf(args.StdinData)
+// reserveVFs reserves the VFs of bondedlist for the attachment, assigning a
+// free VF of the master to n first if assign is set, then sets the VLAN picked
+// by the vlanSelection mode of n on them
+func reserveVFs(args *skel.CmdArgs, n *sriovtypes.NetConf, bondedlist []*sriovtypes.NetConf, assign bool) error {
+	alloc, err := allocator.Open(n.CNIDir)
 	if err != nil {
 		return err
 	}
+	defer alloc.Close()
 
-	podname := getPodName(args)
-	if args.Netns == "" {
-		logging.Debugf("cmdDel return podname %s ifname %s", podname, args.IfName)
-		return nil
+	if assign {
+		if err = config.AssignFreeVF(n, alloc); err != nil {
+			return fmt.Errorf("SRIOV-CNI failed to assign a free VF of %q: %v", n.Master, err)
+		}
 	}
 
-	netns, err := ns.GetNS(args.Netns)
+	for _, slave := range bondedlist {
+		if err = alloc.Reserve(slave.DeviceInfo.PCIaddr, args.ContainerID, args.IfName); err != nil {
+			alloc.Release(args.ContainerID, args.IfName)
+			return err
+		}
+	}
+
+	vlan, err := config.SelectVlan(n, args.ContainerID, args.IfName, args.Args, alloc)
 	if err != nil {
-		// according to:
-		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
-		// if provided path does not exist (e.x. when node was restarted)
-		// plugin should silently return with success after releasing
-		// IPAM resources
-		_, ok := err.(ns.NSPathNotExistErr)
-		if ok {
-			return nil
+		alloc.Release(args.ContainerID, args.IfName)
+		return err
+	}
+	if vlan != 0 {
+		for _, slave := range bondedlist {
+			slave.Vlan = vlan
 		}
+		logging.Debugf("reserveVFs podifname %s, vlan %d vlans %v", args.IfName, vlan, n.Vlans)
+	}
 
-		logging.Debugf("cmdDel error1 podname %s ifname %s", podname, args.IfName)
-		return fmt.Errorf("failed to open netns %q %v", netns, err)
+	return nil
+}
+
+// releaseVFs drops the VF reservations of the attachment
+func releaseVFs(args *skel.CmdArgs, dataDir string) error {
+	alloc, err := allocator.Open(dataDir)
+	if err != nil {
+		return err
 	}
-	defer netns.Close()
+	defer alloc.Close()
 
-	if bondedlist != nil {
-		for i, slave := range bondedlist {
-			ifname := args.IfName + "-" + strconv.Itoa(i)
-			if err = releaseVF(slave, ifname, args.ContainerID, netns); err != nil {
-				logging.Debugf("cmdDel releaseVF error1 podname %s ifname %s", podname, ifname)
-				return err
+	return alloc.Release(args.ContainerID, args.IfName)
+}
+
+// releasePfMtus lowers the raised MTUs of the PFs of the VFs of st back to the
+// value recorded in the allocator, whichever attachment raised them. A PF is
+// left as is while a VF of it is reserved by another attachment, which may
+// rely on the raised MTU.
+func releasePfMtus(args *skel.CmdArgs, dataDir string, st *state.State) error {
+	alloc, err := allocator.Open(dataDir)
+	if err != nil {
+		return err
+	}
+	defer alloc.Close()
+
+	self := allocator.Reservation{ContainerID: args.ContainerID, IfName: args.IfName}
+	for _, vf := range st.VFs {
+		m, ok := alloc.PfMtu(vf.PFName)
+		if !ok {
+			continue
+		}
+		shared, err := pfSharedByOthers(vf.PFName, alloc, self)
+		if err != nil {
+			return err
+		}
+		if shared {
+			logging.Debugf("releasePfMtus PF %s has VFs of other attachments, mtu left raised", vf.PFName)
+			continue
+		}
+		if err = restorePfMtu(vf.PFName, m.Raised, m.Orig); err != nil {
+			return err
+		}
+		if err = alloc.DropPfMtu(vf.PFName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pfSharedByOthers reports whether a VF of the PF pfName is reserved in alloc
+// by an attachment other than self
+func pfSharedByOthers(pfName string, alloc *allocator.Allocator, self allocator.Reservation) (bool, error) {
+	vfTotal, err := utils.GetSriovNumVfs(pfName)
+	if err != nil {
+		return false, err
+	}
+	for vf := 0; vf < vfTotal; vf++ {
+		pciAddr, err := utils.GetPciAddress(pfName, vf)
+		if err != nil {
+			continue
+		}
+		if owner, ok := alloc.Owner(pciAddr); ok && owner != self {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rollbackAdd undoes the steps of a failed ADD recorded in j and lowers the PF
+// MTUs raised for nobody else, then drops the state st and the VF reservations.
+// They are kept if an undo action fails, so that DEL retries the release of
+// the VFs.
+func rollbackAdd(args *skel.CmdArgs, n *sriovtypes.NetConf, st *state.State, j *journal) {
+	if err := j.rollback(); err != nil {
+		logging.Debugf("rollbackAdd rollback failed, keeping the state for DEL: %v", err)
+		return
+	}
+	if err := releasePfMtus(args, n.CNIDir, st); err != nil {
+		logging.Debugf("rollbackAdd releasePfMtus failed, keeping the state for DEL: %v", err)
+		return
+	}
+	if err := state.Delete(n.CNIDir, st.ContainerID, st.IfName); err != nil {
+		logging.Debugf("rollbackAdd state.Delete failed: %v", err)
+		return
+	}
+	if err := releaseVFs(args, n.CNIDir); err != nil {
+		logging.Debugf("rollbackAdd releaseVFs failed: %v", err)
+	}
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	n, err := config.ParseConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	podname := getPodName(args)
+
+	st, err := state.Load(n.CNIDir, args.ContainerID, args.IfName)
+	if err == state.ErrNotFound {
+		// ADD failed before recording any VF, DEL already completed, or
+		// the attachment predates the state store
+		logging.Debugf("cmdDel no state recorded podname %s ifname %s", podname, args.IfName)
+		if err = releaseLegacyDPDKVFs(n.CNIDir, args.ContainerID, args.IfName); err != nil {
+			return err
+		}
+		if err = releaseIPAM(args, n); err != nil {
+			return err
+		}
+		return releaseVFs(args, n.CNIDir)
+	}
+	if err != nil {
+		logging.Debugf("cmdDel state.Load error podname %s ifname %s %v", podname, args.IfName, err)
+		return err
+	}
+
+	// release IPAM resources first, they have to be freed even when the
+	// netns is already gone
+	if n.IPAM.Type != "" {
+		if st.Bond != nil {
+			if !n.L2Mode {
+				if err = execIPAMDel(args, n.IPAM.Type, st.Bond.Name); err != nil {
+					logging.Debugf("cmdDel execIPAMDel error podname %s bond %s %v", podname, st.Bond.Name, err)
+					return err
+				}
+			}
+		} else {
+			for _, vf := range st.VFs {
+				if vf.L2Mode || vf.DPDKBound {
+					continue
+				}
+				if err = execIPAMDel(args, n.IPAM.Type, vf.PodIfName); err != nil {
+					logging.Debugf("cmdDel execIPAMDel error podname %s ifname %s %v", podname, vf.PodIfName, err)
+					return err
+				}
 			}
 		}
-		logging.Debugf("PKKK-E bondedlist cmdDel success podname %s ifname %s", podname, args.IfName)
-		return nil
 	}
 
-	if err = releaseVF(n, args.IfName, args.ContainerID, netns); err != nil {
-		logging.Debugf("cmdDel releaseVF error1 podname %s ifname %s", podname, args.IfName)
+	// without a netns the kernel already moved the VF netdevs back to the
+	// init netns under their pod names, releaseVF renames them there
+	var netns ns.NetNS
+	if args.Netns != "" {
+		netns, err = ns.GetNS(args.Netns)
+		if err != nil {
+			// according to:
+			// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
+			// if provided path does not exist (e.x. when node was restarted)
+			// plugin should silently return with success after releasing
+			// IPAM resources
+			_, notExist := err.(ns.NSPathNotExistErr)
+			_, notNS := err.(ns.NSPathNotNSErr)
+			if !notExist && !notNS {
+				logging.Debugf("cmdDel error1 podname %s ifname %s", podname, args.IfName)
+				return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+			}
+			netns = nil
+		} else {
+			defer netns.Close()
+		}
+	}
+
+	// the bond has to go before its slaves are returned to the host
+	if st.Bond != nil && netns != nil {
+		if err = deleteBond(st.Bond.Name, netns); err != nil {
+			logging.Debugf("cmdDel deleteBond error podname %s bond %s %v", podname, st.Bond.Name, err)
+			return err
+		}
+	}
+
+	for i := len(st.VFs) - 1; i >= 0; i-- {
+		if err = releaseVF(st.VFs[i], args.ContainerID, netns); err != nil {
+			logging.Debugf("cmdDel releaseVF error1 podname %s ifname %s", podname, st.VFs[i].PodIfName)
+			return err
+		}
+	}
+
+	if err = releasePfMtus(args, n.CNIDir, st); err != nil {
+		logging.Debugf("cmdDel releasePfMtus error podname %s ifname %s %v", podname, args.IfName, err)
+		return err
+	}
+
+	// the state goes last so that a failed DEL can be retried with it
+	if err = state.Delete(n.CNIDir, args.ContainerID, args.IfName); err != nil {
+		return err
+	}
+	if err = releaseVFs(args, n.CNIDir); err != nil {
 		return err
 	}
 
@@ -227,7 +440,50 @@ func cmdDel(args *skel.CmdArgs) error {
 	return nil
 }
 
+// releaseIPAM releases the IPAM allocations of an attachment with no state
+// recorded, the pod interface names are derived from the configuration
+func releaseIPAM(args *skel.CmdArgs, n *sriovtypes.NetConf) error {
+	if n.L2Mode || n.IPAM.Type == "" {
+		return nil
+	}
+
+	devices := n.Devices
+	if n.DeviceID != "" {
+		for _, deviceID := range strings.Split(n.DeviceID, "-") {
+			devices = append(devices, sriovtypes.DeviceConf{DeviceID: deviceID})
+		}
+	}
+
+	var ifNames []string
+	switch {
+	case n.Bond != nil || len(devices) == 0:
+		if n.DPDKConf == nil {
+			ifNames = append(ifNames, args.IfName)
+		}
+	default:
+		for i, dev := range devices {
+			if dev.DPDKConf != nil || n.DPDKConf != nil {
+				continue
+			}
+			slave := &sriovtypes.NetConf{PodIfName: dev.IfName}
+			ifNames = append(ifNames, podIfName(slave, args.IfName, i, false))
+		}
+	}
+
+	for _, ifName := range ifNames {
+		if err := execIPAMDel(args, n.IPAM.Type, ifName); err != nil {
+			logging.Debugf("releaseIPAM execIPAMDel error ifname %s %v", ifName, err)
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
+	if os.Getenv("CNI_COMMAND") == "CHECK" {
+		checkPluginMain()
+		return
+	}
 	//skel.PluginMain(cmdAdd, cmdDel, version.Legacy)
-	skel.PluginMain(cmdAdd, cmdDel, version.PluginSupports("0.3.0", "0.3.0"))
+	skel.PluginMain(cmdAdd, cmdDel, version.PluginSupports("0.3.0", "0.3.1", "0.4.0"))
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/intel/sriov-cni/pkg/allocator"
+	"github.com/intel/sriov-cni/pkg/state"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("Main", func() {
+	Context("Checking podIfName function", func() {
+		It("Assuming a single deviceID", func() {
+			Expect(podIfName(&sriovtypes.NetConf{}, "net1", 0, false)).To(Equal("net1-0"))
+		})
+		It("Assuming several devices", func() {
+			Expect(podIfName(&sriovtypes.NetConf{}, "net1", 1, false)).To(Equal("net1-1"))
+		})
+		It("Assuming a VF assigned from the master", func() {
+			Expect(podIfName(&sriovtypes.NetConf{}, "net1", 0, true)).To(Equal("net1"))
+		})
+		It("Assuming an ifname given for the device", func() {
+			Expect(podIfName(&sriovtypes.NetConf{PodIfName: "data"}, "net1", 0, false)).To(Equal("data"))
+		})
+	})
+	Context("Checking pfSharedByOthers function", func() {
+		var dataDir string
+		var alloc *allocator.Allocator
+		self := allocator.Reservation{ContainerID: "cid1", IfName: "net1"}
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "sriovplugin-data-")
+			Expect(err).NotTo(HaveOccurred())
+			alloc, err = allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(alloc.Reserve("0000:af:06.0", self.ContainerID, self.IfName)).To(Succeed())
+		})
+		AfterEach(func() {
+			Expect(alloc.Close()).To(Succeed())
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
+		It("Assuming the PF VFs only reserved by the attachment", func() {
+			Expect(pfSharedByOthers("enp175s0f1", alloc, self)).To(BeFalse())
+		})
+		It("Assuming a PF VF reserved by another attachment", func() {
+			Expect(alloc.Reserve("0000:af:06.1", "cid2", "net1")).To(Succeed())
+			Expect(pfSharedByOthers("enp175s0f1", alloc, self)).To(BeTrue())
+		})
+		It("Assuming a VF of another PF reserved by another attachment", func() {
+			Expect(alloc.Reserve("0000:af:02.0", "cid2", "net1")).To(Succeed())
+			Expect(pfSharedByOthers("enp175s0f1", alloc, self)).To(BeFalse())
+		})
+	})
+	Context("Checking releasePfMtus function", func() {
+		// a veth stands for the PF enp175s0f1 of the fake sysfs
+		const pfName = "enp175s0f1"
+		var dataDir string
+		var pf *netlink.Veth
+		BeforeEach(func() {
+			if os.Getuid() != 0 {
+				Skip("creating netdevs requires root")
+			}
+			var err error
+			dataDir, err = ioutil.TempDir("", "sriovplugin-data-")
+			Expect(err).NotTo(HaveOccurred())
+
+			pf = &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: pfName, MTU: 9000}, PeerName: "sriovt-pfpeer"}
+			Expect(netlink.LinkAdd(pf)).To(Succeed())
+
+			// cid1 raised the PF, cid2 relies on the raised MTU
+			alloc, err := allocator.Open(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			defer alloc.Close()
+			Expect(alloc.Reserve("0000:af:06.0", "cid1", "net1")).To(Succeed())
+			Expect(alloc.Reserve("0000:af:06.1", "cid2", "net1")).To(Succeed())
+			Expect(alloc.RaisePfMtu(pfName, 1500, 9000)).To(Succeed())
+		})
+		AfterEach(func() {
+			netlink.LinkDel(pf)
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
+		pfMTU := func() int {
+			link, err := netlink.LinkByName(pfName)
+			Expect(err).NotTo(HaveOccurred())
+			return link.Attrs().MTU
+		}
+		release := func(cid, pciAddr string) {
+			args := &skel.CmdArgs{ContainerID: cid, IfName: "net1"}
+			st := state.New(cid, "net1", "")
+			st.VFs = []*state.VF{{PFName: pfName, PCIAddr: pciAddr}}
+			Expect(releasePfMtus(args, dataDir, st)).To(Succeed())
+			Expect(releaseVFs(args, dataDir)).To(Succeed())
+		}
+		It("Assuming the attachment which raised the PF deleted first", func() {
+			release("cid1", "0000:af:06.0")
+			Expect(pfMTU()).To(Equal(9000), "The PF should stay raised while cid2 holds a VF")
+			release("cid2", "0000:af:06.1")
+			Expect(pfMTU()).To(Equal(1500), "The last attachment should restore the PF MTU")
+		})
+		It("Assuming the attachment which raised the PF deleted last", func() {
+			release("cid2", "0000:af:06.1")
+			Expect(pfMTU()).To(Equal(9000))
+			release("cid1", "0000:af:06.0")
+			Expect(pfMTU()).To(Equal(1500))
+		})
+	})
+})
@@ -3,42 +3,89 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/containernetworking/cni/pkg/ns"
 	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/allocator"
 	"github.com/intel/sriov-cni/pkg/config"
+	"github.com/intel/sriov-cni/pkg/devicedb"
 	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/state"
 	sriovtypes "github.com/intel/sriov-cni/pkg/types"
 	"github.com/intel/sriov-cni/pkg/utils"
+	"github.com/intel/sriov-cni/pkg/vfdriver"
 	"github.com/vishvananda/netlink"
+	vnetns "github.com/vishvananda/netns"
 )
 
 /*
  Link names given as os.FileInfo need to be sorted by their Index
 */
 
-// LinksByIndex holds network interfaces name
-type LinksByIndex []string
+// LinksByIndex holds network interfaces name and their ifindex
+type LinksByIndex struct {
+	names   []string
+	indexes map[string]int
+}
 
 // LinksByIndex implements sort.Inteface
-func (l LinksByIndex) Len() int { return len(l) }
+func (l LinksByIndex) Len() int { return len(l.names) }
 
 // Swap implements Swap() method of sort interface
-func (l LinksByIndex) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l LinksByIndex) Swap(i, j int) { l.names[i], l.names[j] = l.names[j], l.names[i] }
 
 // Less implements Less() method of sort interface
 func (l LinksByIndex) Less(i, j int) bool {
-	linkA, _ := netlink.LinkByName(l[i])
-	linkB, _ := netlink.LinkByName(l[j])
+	return l.indexes[l.names[i]] < l.indexes[l.names[j]]
+}
+
+// sortLinksByIndex sorts the network interfaces names of the netns of h by
+// their ifindex, resolved once before sorting
+func sortLinksByIndex(h *netlink.Handle, names []string) error {
+	indexes := make(map[string]int, len(names))
+	for _, name := range names {
+		link, err := h.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("failed to lookup vf device %q: %v", name, err)
+		}
+		indexes[name] = link.Attrs().Index
+	}
+
+	sort.Sort(LinksByIndex{names: names, indexes: indexes})
+	return nil
+}
+
+// withHandle runs fn with a netlink handle on the network namespace netns.
+// The thread stays in its own namespace, only the netlink sockets of the
+// handle are opened in netns.
+func withHandle(netns ns.NetNS, fn func(*netlink.Handle) error) error {
+	h, err := netlink.NewHandleAt(vnetns.NsHandle(netns.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink handle on netns %q: %v", netns.Path(), err)
+	}
+	defer h.Delete()
+
+	return fn(h)
+}
+
+// withHostHandle runs fn with a netlink handle on the init netns, where the
+// plugin runs and the PFs live
+func withHostHandle(fn func(*netlink.Handle) error) error {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink handle on init netns: %v", err)
+	}
+	defer h.Delete()
 
-	return linkA.Attrs().Index < linkB.Attrs().Index
+	return fn(h)
 }
 
-func setSharedVfVlan(ifName string, vfIdx int, vlan int) error {
+func setSharedVfVlan(ifName string, vfIdx int, vlan, qos int, proto string) error {
 	var err error
 	var sharedifName string
 
@@ -68,54 +115,92 @@ func setSharedVfVlan(ifName string, vfIdx int, vlan int) error {
 		return fmt.Errorf("Shared ifname can't be empty")
 	}
 
-	iflink, err := netlink.LinkByName(sharedifName)
-	if err != nil {
-		return fmt.Errorf("failed to lookup the shared ifname %q: %v", sharedifName, err)
-	}
-
-	if err := netlink.LinkSetVfVlan(iflink, vfIdx, vlan); err != nil {
+	if err := utils.SetVfVlan(sharedifName, vfIdx, vlan, qos, proto); err != nil {
 		return fmt.Errorf("failed to set vf %d vlan: %v for shared ifname %q", vfIdx, err, sharedifName)
 	}
 
 	return nil
 }
 
-func moveIfToNetns(ifname string, netns ns.NetNS) (string, error) {
-	vfDev, err := netlink.LinkByName(ifname)
+// moveIfToNetns renames the netdev ifname of the init netns of host to
+// dev<ifindex> and moves it into netns, registering the undo actions in j. It
+// returns the name of the netdev in netns.
+func moveIfToNetns(host *netlink.Handle, ifname string, netns ns.NetNS, j *journal) (string, error) {
+	vfDev, err := host.LinkByName(ifname)
 	if err != nil {
 		logging.Debugf("moveIfToNetns error netlink.LinkByName has failed %s %v", ifname, err)
 		return ifname, fmt.Errorf("failed to lookup vf device %v: %q", ifname, err)
 	}
 
-	if err = netlink.LinkSetDown(vfDev); err != nil {
+	if err = host.LinkSetDown(vfDev); err != nil {
 		logging.Debugf("moveIfToNetns error netlink.LinkSetDown has failed %s %v", ifname, err)
 		return ifname, fmt.Errorf("failed to down vf device %q: %v", ifname, err)
 	}
 	index := vfDev.Attrs().Index
 	vfName := fmt.Sprintf("dev%d", index)
-	if renameLink(ifname, vfName); err != nil {
+	if err = renameLink(host, ifname, vfName); err != nil {
 		logging.Debugf("moveIfToNetns error renameLink has failed %s %s %v", ifname, vfName, err)
 		return ifname, fmt.Errorf("failed to rename vf device %q to %q: %v", ifname, vfName, err)
 	}
+	j.add("rename "+ifname, func() error {
+		return withHostHandle(func(h *netlink.Handle) error {
+			return renameLink(h, vfName, ifname)
+		})
+	})
 
-	if err = netlink.LinkSetUp(vfDev); err != nil {
+	if err = host.LinkSetUp(vfDev); err != nil {
 		logging.Debugf("moveIfToNetns error netlink.LinkSetUp has failed %v %s %v", vfDev, ifname, err)
 		return vfName, fmt.Errorf("failed to setup netlink device %v %q", ifname, err)
 	}
 
 	// move VF device to ns
-	if err = netlink.LinkSetNsFd(vfDev, int(netns.Fd())); err != nil {
+	if err = host.LinkSetNsFd(vfDev, int(netns.Fd())); err != nil {
 		logging.Debugf("moveIfToNetns error netlink.LinkSetNsFd has failed %v %s %v", vfDev, ifname, err)
 		return vfName, fmt.Errorf("failed to move device %+v to netns: %q", ifname, err)
 	}
+	j.add("move "+ifname, func() error {
+		return moveLinkToHost(vfName, netns)
+	})
 
 	logging.Debugf("moveIfToNetns vfdev %v ifname %s ns %v", vfDev, ifname, netns)
 
 	return vfName, nil
 }
 
-func setupVF(conf *sriovtypes.NetConf, podifName string, cid string, netns ns.NetNS) error {
-	m, err := netlink.LinkByName(conf.Master)
+// moveLinkToHost moves the netdev ifName of netns down to the init netns
+func moveLinkToHost(ifName string, netns ns.NetNS) error {
+	initns, err := ns.GetCurrentNS()
+	if err != nil {
+		return fmt.Errorf("failed to get init netns: %v", err)
+	}
+	defer initns.Close()
+
+	return withHandle(netns, func(pod *netlink.Handle) error {
+		link, err := pod.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup vf device %q: %v", ifName, err)
+		}
+		if err = pod.LinkSetDown(link); err != nil {
+			return fmt.Errorf("failed to down vf device %q: %v", ifName, err)
+		}
+		if err = pod.LinkSetNsFd(link, int(initns.Fd())); err != nil {
+			return fmt.Errorf("failed to move vf device %q to init netns: %v", ifName, err)
+		}
+		return nil
+	})
+}
+
+// setupVF configures the VF of conf, recorded in vf, on the PF and moves it
+// into netns as podifName, or binds it to its DPDK driver. The undo action of
+// every completed step is registered in j.
+func setupVF(conf *sriovtypes.NetConf, vf *state.VF, podifName string, cid string, netns ns.NetNS, j *journal) error {
+	host, err := netlink.NewHandle()
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink handle on init netns: %v", err)
+	}
+	defer host.Delete()
+
+	m, err := host.LinkByName(conf.Master)
 	if err != nil {
 		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
 	}
@@ -124,72 +209,154 @@ func setupVF(conf *sriovtypes.NetConf, podifName string, cid string, netns ns.Ne
 	if err != nil {
 		return fmt.Errorf("failed to determine if interface should have netlink device: %v", err)
 	}
-	if !netlinkExpected {
+	// VFs bound to a userspace driver have no netdev, in DPDK mode they are
+	// handed to the pod as they are and only set up on the PF
+	if !netlinkExpected && !conf.DPDKMode {
 		return nil
 	}
 
-	vfLinks, err := utils.GetVFLinkNames(conf.Master, conf.DeviceInfo.Vfid)
+	var vfLinks []string
+	if netlinkExpected {
+		vfLinks, err = utils.GetVFLinkNames(conf.Master, conf.DeviceInfo.Vfid)
+		if err != nil {
+			return err
+		}
+	}
+
+	drv, err := vfdriver.ForDevice(conf.DeviceInfo.PCIaddr)
 	if err != nil {
 		return err
 	}
+	vfID := conf.DeviceInfo.Vfid
 
 	if conf.Vlan != 0 {
-		if err = netlink.LinkSetVfVlan(m, conf.DeviceInfo.Vfid, conf.Vlan); err != nil {
-			return fmt.Errorf("failed to set vf %d vlan: %v", conf.DeviceInfo.Vfid, err)
+		if err = utils.SetVfVlan(conf.Master, vfID, conf.Vlan, conf.VlanQoS, conf.VlanProto); err != nil {
+			return fmt.Errorf("failed to set vf %d vlan: %v", vfID, err)
 		}
+		j.add("vlan", func() error {
+			return drv.ResetVlan(conf.Master, vfID)
+		})
 
 		if conf.Sharedvf {
-			if err = setSharedVfVlan(conf.Master, conf.DeviceInfo.Vfid, conf.Vlan); err != nil {
-				return fmt.Errorf("failed to set shared vf %d vlan: %v", conf.DeviceInfo.Vfid, err)
+			if err = setSharedVfVlan(conf.Master, vfID, conf.Vlan, conf.VlanQoS, conf.VlanProto); err != nil {
+				return fmt.Errorf("failed to set shared vf %d vlan: %v", vfID, err)
 			}
+			j.add("shared vlan", func() error {
+				return setSharedVfVlan(conf.Master, vfID, 0, 0, utils.VlanProto8021Q)
+			})
 		}
 	}
 
+	var hwaddr net.HardwareAddr
+	if conf.MAC != "" {
+		hwaddr, err = net.ParseMAC(conf.MAC)
+		if err != nil {
+			return fmt.Errorf("failed to parse vf %d mac %q: %v", vfID, conf.MAC, err)
+		}
+		if err = drv.SetMAC(conf.Master, vfID, hwaddr); err != nil {
+			return err
+		}
+		j.add("mac", func() error {
+			origMAC, err := net.ParseMAC(vf.Orig.MAC)
+			if err != nil {
+				return fmt.Errorf("failed to parse the original mac %q of vf %d: %v", vf.Orig.MAC, vfID, err)
+			}
+			return drv.SetMAC(conf.Master, vfID, origMAC)
+		})
+	}
+
+	if err = setVfSettings(conf, drv.Device(), vf.Orig, j); err != nil {
+		return err
+	}
+
 	logging.Debugf("setupVF start cid : %s, podifname %s, ns %v", cid, podifName, netns)
-	logging.Debugf("setupVF master %s, vf %d pf %s pcie %s ", conf.Master, conf.DeviceInfo.Vfid, conf.DeviceInfo.Pfname, conf.DeviceInfo.PCIaddr)
+	logging.Debugf("setupVF master %s, vf %d pf %s pcie %s ", conf.Master, vfID, conf.DeviceInfo.Pfname, conf.DeviceInfo.PCIaddr)
 	logging.Debugf("setupVF DPDK %t L2 %t Vlan %d deviceId %s", conf.DPDKMode, conf.L2Mode, conf.Vlan, conf.DeviceID)
 
-	// /sys/class/net/enp59s0/device/device check for 0x1017 ConnectX5 - then ignore DPDK conf and always bind to kernel driver
-	// /sys/class/net/enp59s0/device/driver -> ../../../../bus/pci/drivers/mlx5_core  - points to mlx5_core
+	// bifurcated VFs are used by DPDK through their kernel driver, they
+	// are added as L2 interfaces
 	if conf.DPDKMode {
-		dpdkbind, netdriver, err := utils.GetDPDKbind(conf.DeviceInfo.PCIaddr, conf.DeviceInfo.Pfname, conf.DeviceInfo.Vfid)
-		if err != nil {
-			logging.Debugf("setupVF utils.GetDPDKbind failed %v", err)
-			return fmt.Errorf("setupVF utils.GetDPDKbind failed %v", err)
+		if !netlinkExpected {
+			logging.Debugf("setupVF VF %s is already bound to a userspace driver - cid : %s, podifname %s", conf.DeviceInfo.PCIaddr, cid, podifName)
+			if vf.Orig.Driver != dpdk.VfioDriver {
+				return nil
+			}
+			if err = dpdk.CheckIOMMUGroup(conf.DeviceInfo.PCIaddr); err != nil {
+				return err
+			}
+			return setupVfio(conf, vf, j)
 		}
-		if err = dpdk.SaveDpdkConf(cid, conf.CNIDir, conf.DPDKConf); err != nil {
-			logging.Debugf("setupVF dpdk.SaveDpdkConf failed %v", err)
-			return err
+		// whether DPDK needs the VF on dpdk_driver or on its kernel
+		// driver is only known for the VFs of the device database
+		if !drv.Device().Known() {
+			dev := drv.Device()
+			return utils.NewError(utils.ErrUnknownDevice, "", "VF %q, PCI ID %s:%s, is missing from the device database, DPDK mode can't be set up", conf.DeviceInfo.PCIaddr, dev.Vendor, dev.Device)
 		}
-		if dpdkbind {
+		if drv.NeedsDPDKBind() {
+			// vfio hands out whole IOMMU groups, the group is checked
+			// before the VF leaves its kernel driver
+			if conf.DPDKConf.DPDKDriver == dpdk.VfioDriver {
+				if err = dpdk.CheckIOMMUGroup(conf.DeviceInfo.PCIaddr); err != nil {
+					return err
+				}
+			}
 			logging.Debugf("setupVF binding DPDK")
-			rc := dpdk.Enabledpdkmode(conf.DPDKConf, vfLinks[0], true)
+			// the bind unbinds the VF first, a bind failing after that
+			// leaves the VF without driver, releaseDPDKVF skips a VF
+			// still on its kernel driver
+			j.add("dpdk bind", func() error {
+				return releaseDPDKVF(vf)
+			})
+			var origDriver string
+			if origDriver, err = drv.BindDPDK(conf.DPDKConf); err != nil {
+				return err
+			}
+			// the VF is bound back to the driver found by the bind
+			if origDriver != "" {
+				vf.Orig.Driver = origDriver
+			}
+			if conf.DPDKConf.DPDKDriver == dpdk.VfioDriver {
+				if err = setupVfio(conf, vf, j); err != nil {
+					return err
+				}
+			}
 			logging.Debugf("setupVF DPDK complete - cid : %s, podifname %s, ns %v", cid, podifName, netns)
-			return rc
+			return nil
 		}
-		logging.Debugf("setupVF DPDKMode enabled but not binding DPDK igb_uio driver : driver %s pciaddr %s pf %s vf %d",
-			netdriver, conf.DeviceInfo.PCIaddr, conf.DeviceInfo.Pfname, conf.DeviceInfo.Vfid)
+		logging.Debugf("setupVF DPDKMode enabled but not binding DPDK igb_uio driver : device %s pciaddr %s pf %s vf %d",
+			drv.Device().Name, conf.DeviceInfo.PCIaddr, conf.DeviceInfo.Pfname, vfID)
 		// bind the netdriver
 		conf.L2Mode = true
 	}
 
+	// the PF only has to carry the MTU of the VF netdevs, DPDK bound VFs
+	// returned above, a raised PF MTU is lowered by releasePfMtus once no
+	// attachment holds a VF of the PF
+	if conf.MTU != 0 {
+		if err = checkPfMtu(host, m, conf); err != nil {
+			return err
+		}
+	}
+
 	// Sort links name if there are 2 or more PF links found for a VF;
 	if len(vfLinks) > 1 {
 		// sort Links FileInfo by their Link indices
-		sort.Sort(LinksByIndex(vfLinks))
+		if err = sortLinksByIndex(host, vfLinks); err != nil {
+			return err
+		}
 	}
 
 	for i := 0; i < len(vfLinks); i++ {
 		linkName := vfLinks[i]
 
-		newLinkName, err := moveIfToNetns(linkName, netns)
+		newLinkName, err := moveIfToNetns(host, linkName, netns, j)
 		if err != nil {
 			return err
 		}
 		vfLinks[i] = newLinkName
 	}
 
-	return netns.Do(func(_ ns.NetNS) error {
+	return withHandle(netns, func(pod *netlink.Handle) error {
 
 		ifName := podifName
 		for i := 0; i < len(vfLinks); i++ {
@@ -197,15 +364,49 @@ func setupVF(conf *sriovtypes.NetConf, podifName string, cid string, netns ns.Ne
 				ifName = podifName + fmt.Sprintf("d%d", i)
 			}
 
-			err := renameLink(vfLinks[i], ifName)
+			err := renameLink(pod, vfLinks[i], ifName)
 			if err != nil {
 				logging.Debugf("setupVF renameLink failed %v", err)
-				return fmt.Errorf("failed to rename vf %d of the device %q to %q: %v", conf.DeviceInfo.Vfid, vfLinks[i], ifName, err)
+				return fmt.Errorf("failed to rename vf %d of the device %q to %q: %v", vfID, vfLinks[i], ifName, err)
+			}
+			podName, linkName := ifName, vfLinks[i]
+			j.add("rename "+podName, func() error {
+				return withHandle(netns, func(h *netlink.Handle) error {
+					return renameLink(h, podName, linkName)
+				})
+			})
+
+			// the netdev does not pick up the MAC set on the PF until the
+			// VF is reset
+			if hwaddr != nil {
+				if err = setLinkHardwareAddr(pod, ifName, hwaddr); err != nil {
+					return err
+				}
+				if origMAC, err := net.ParseMAC(vf.Orig.NetdevMAC); err == nil {
+					j.add("netdev mac "+podName, func() error {
+						return withHandle(netns, func(h *netlink.Handle) error {
+							return setLinkHardwareAddr(h, podName, origMAC)
+						})
+					})
+				}
+			}
+
+			if conf.MTU != 0 {
+				if err = setLinkMTU(pod, ifName, conf.MTU); err != nil {
+					return err
+				}
+				if vf.Orig.MTU != 0 {
+					j.add("mtu "+podName, func() error {
+						return withHandle(netns, func(h *netlink.Handle) error {
+							return setLinkMTU(h, podName, vf.Orig.MTU)
+						})
+					})
+				}
 			}
 
 			// for L2 mode enable the pod net interface
 			if conf.L2Mode != false {
-				err = setUpLink(ifName)
+				err = setUpLink(pod, ifName)
 				if err != nil {
 					logging.Debugf("setupVF setUpLink failed %v", err)
 					return fmt.Errorf("failed to set up the pod interface name %q: %v", ifName, err)
@@ -217,154 +418,426 @@ func setupVF(conf *sriovtypes.NetConf, podifName string, cid string, netns ns.Ne
 	})
 }
 
-func releaseVF(conf *sriovtypes.NetConf, podifName string, cid string, netns ns.NetNS) error {
-	// check for the DPDK mode and release the allocated DPDK resources
-	logging.Debugf("releaseVF start cid : %s, podifname %s, ns %v", cid, podifName, netns)
-	logging.Debugf("releaseVF master %s, vf %d pf %s pcie %s ", conf.Master, conf.DeviceInfo.Vfid, conf.DeviceInfo.Pfname, conf.DeviceInfo.PCIaddr)
-	logging.Debugf("releaseVF DPDK %t L2 %t Vlan %d deviceId %s", conf.DPDKMode, conf.L2Mode, conf.Vlan, conf.DeviceID)
-	if conf.DPDKMode != false {
-		dpdkbind, netdriver, err := utils.GetDPDKbind(conf.DeviceInfo.PCIaddr, conf.DeviceInfo.Pfname, conf.DeviceInfo.Vfid)
-		if err != nil {
-			logging.Debugf("releaseVF utils.GetDPDKbind failed %v", err)
-			return fmt.Errorf("releaseVF utils.GetDPDKbind failed %v", err)
+// setupVfio records in vf the IOMMU group of the VF of conf, bound to
+// vfio-pci, and hands the vfio device node of the group to the vfio_uid and
+// vfio_gid of conf if set, registering its undo action in j
+func setupVfio(conf *sriovtypes.NetConf, vf *state.VF, j *journal) error {
+	group, err := dpdk.IOMMUGroup(conf.DeviceInfo.PCIaddr)
+	if err != nil {
+		return err
+	}
+	vf.IOMMUGroup = group
+
+	dc := conf.DPDKConf
+	if dc.VfioUID == nil && dc.VfioGID == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if dc.VfioUID != nil {
+		uid = *dc.VfioUID
+	}
+	if dc.VfioGID != nil {
+		gid = *dc.VfioGID
+	}
+	orig, err := dpdk.SetVfioOwner(group, uid, gid, dc.ReadyWait())
+	if err != nil {
+		return err
+	}
+	vf.Orig.VfioUID = orig.UID
+	vf.Orig.VfioGID = orig.GID
+	vf.Orig.VfioMode = orig.Mode
+	j.add("vfio owner", func() error {
+		return dpdk.RestoreVfioOwner(group, orig)
+	})
+
+	logging.Debugf("setupVfio VF %s group %s owner %d:%d", conf.DeviceInfo.PCIaddr, group, uid, gid)
+	return nil
+}
+
+// releaseVF returns the VF recorded in vf to the host: DPDK bound VFs are
+// bound back to their kernel driver, the VF netdev is moved back from netns,
+// or renamed in the init netns if netns is nil, and the VF settings are reset
+// on the PF. Steps which are already undone are skipped so that releaseVF can
+// be retried.
+func releaseVF(vf *state.VF, cid string, netns ns.NetNS) error {
+	logging.Debugf("releaseVF start cid : %s, podifname %s, ns %v", cid, vf.PodIfName, netns)
+	logging.Debugf("releaseVF pf %s, vf %d pcie %s DPDK %t L2 %t Vlan %d", vf.PFName, vf.VFID, vf.PCIAddr, vf.DPDKBound, vf.L2Mode, vf.Vlan)
+
+	// the vfio group node is given back before the VF leaves vfio-pci,
+	// which removes the node
+	if vf.IOMMUGroup != "" && vf.DPDKConf != nil && (vf.DPDKConf.VfioUID != nil || vf.DPDKConf.VfioGID != nil) {
+		orig := dpdk.VfioOwner{UID: vf.Orig.VfioUID, GID: vf.Orig.VfioGID, Mode: vf.Orig.VfioMode}
+		if err := dpdk.RestoreVfioOwner(vf.IOMMUGroup, orig); err != nil {
+			return err
 		}
-		if dpdkbind {
-			// get the DPDK net conf in cniDir
-			df, err := dpdk.GetConf(cid, podifName, conf.CNIDir)
-			if err != nil {
-				logging.Debugf("releaseVF dpdk.GetConf failed %v", err)
+	}
+
+	if vf.DPDKBound {
+		if err := releaseDPDKVF(vf); err != nil {
+			return err
+		}
+	}
+
+	// the VF settings are reset on the PF first, some VF drivers refuse a
+	// netdev MAC change while the PF enforces one
+	if err := resetVF(vf); err != nil {
+		return err
+	}
+
+	if !vf.DPDKBound && vf.HostIfName != "" {
+		if netns != nil {
+			if err := moveVFToHost(vf, netns); err != nil {
 				return err
 			}
+		} else if err := restoreHostVF(vf); err != nil {
+			return err
+		}
+	}
 
-			logging.Debugf("releaseVF unbind dpdk : pcieaddr %s ifname %s, kdriver %s dpdkdriver %s dpdktool %s vfid %d", df.PCIaddr, df.Ifname, df.KDriver, df.DPDKDriver, df.DPDKtool, df.VFID)
+	logging.Debugf("releaseVF complete - cid : %s, podifname %s", cid, vf.PodIfName)
+	return nil
+}
 
-			// bind the sriov vf to the kernel driver
-			if err := dpdk.Enabledpdkmode(df, df.Ifname, false); err != nil {
-				logging.Debugf("releaseVF dpdk.Enabledpdkmode failed conf %v podif %s err %v", conf, podifName, err)
-				return fmt.Errorf("DPDK: failed to bind %s to kernel space: %s", df.Ifname, err)
-			}
+// setVfSettings applies the spoofchk, trust, link_state and rate options of
+// conf on the PF, rejecting those not supported by the VF model dev. The undo
+// actions restoring the settings orig are registered in j.
+func setVfSettings(conf *sriovtypes.NetConf, dev devicedb.Device, orig state.OrigVF, j *journal) error {
+	vfID := conf.DeviceInfo.Vfid
+	if err := checkVfCapabilities(conf, dev); err != nil {
+		return err
+	}
+	if conf.SpoofChk != "" {
+		if err := utils.SetVfSpoofchk(conf.Master, vfID, conf.SpoofChk == "on"); err != nil {
+			return err
+		}
+		j.add("spoofchk", func() error {
+			return utils.SetVfSpoofchk(conf.Master, vfID, orig.Spoofchk)
+		})
+	}
+	if conf.Trust != "" {
+		if err := utils.SetVfTrust(conf.Master, vfID, conf.Trust == "on"); err != nil {
+			return err
+		}
+		j.add("trust", func() error {
+			return utils.SetVfTrust(conf.Master, vfID, orig.Trust)
+		})
+	}
+	if conf.LinkState != "" {
+		if err := utils.SetVfLinkState(conf.Master, vfID, utils.VfLinkStates[conf.LinkState]); err != nil {
+			return err
+		}
+		j.add("link_state", func() error {
+			return utils.SetVfLinkState(conf.Master, vfID, orig.LinkState)
+		})
+	}
+	if conf.MinTxRate != 0 || conf.MaxTxRate != 0 {
+		if err := utils.SetVfRate(conf.Master, vfID, conf.MinTxRate, conf.MaxTxRate); err != nil {
+			return err
+		}
+		// a zero rate leaves the VF unlimited
+		j.add("tx rate", func() error {
+			return utils.SetVfRate(conf.Master, vfID, 0, 0)
+		})
+	}
+	return nil
+}
 
-			// PK FIX ME
-			// unbinding from DPDK and binding to kernel driver takes a few seconds
-			// the VLAN resetting call below is failing for i40e which takes couple of seconds
-			time.Sleep(2 * time.Second)
+// checkVfCapabilities verifies that the VF model dev supports the settings of
+// conf
+func checkVfCapabilities(conf *sriovtypes.NetConf, dev devicedb.Device) error {
+	unsupported := ""
+	switch {
+	case conf.SpoofChk != "" && !dev.SupportsSpoofchk:
+		unsupported = "spoofchk"
+	case conf.Trust != "" && !dev.SupportsTrust:
+		unsupported = "trust"
+	case (conf.MinTxRate != 0 || conf.MaxTxRate != 0) && !dev.SupportsRate:
+		unsupported = "tx rates"
+	}
+	if unsupported != "" {
+		return utils.NewError(utils.ErrInvalidVfSetting, "", "%s VF %q does not support %s", dev.Name, conf.DeviceInfo.PCIaddr, unsupported)
+	}
+	return nil
+}
 
-			// reset vlan for DPDK code here
-			pfLink, err := netlink.LinkByName(conf.Master)
-			if err != nil {
-				logging.Debugf("releaseVF netlink.LinkByName failed name %s error %v", conf.Master, err)
-				return fmt.Errorf("DPDK: master device %s not found: %v", conf.Master, err)
-			}
+// vfGone reports whether the VF of vf no longer exists, as after a reboot or a
+// reload of the PF driver, which destroy the VFs along with their settings
+func vfGone(vf *state.VF) bool {
+	_, err := os.Stat(filepath.Join(utils.SysBusPci, vf.PCIAddr))
+	return os.IsNotExist(err)
+}
 
-			if err = netlink.LinkSetVfVlan(pfLink, df.VFID, 0); err != nil {
-				logging.Debugf("releaseVF netlink.LinkSetVfVlan failed vf %d error %v", df.VFID, err)
-				return fmt.Errorf("DPDK: failed to reset vlan tag for vf %d: %v", df.VFID, err)
-			}
-			logging.Debugf("releaseVF in DPDKMode is complete")
-			return nil
-		} // end
-		dpdk.GetConf(cid, podifName, conf.CNIDir)
-		logging.Debugf("releaseVF DPDKMode enabled but not unbinding DPDK igb_uio driver : driver %s pciaddr %s pf %s vf %d",
-			netdriver, conf.DeviceInfo.PCIaddr, conf.DeviceInfo.Pfname, conf.DeviceInfo.Vfid)
-		// bind the netdriver
-		conf.L2Mode = true
+// resetVF resets the VF settings changed by setupVF on the PF, a VF which no
+// longer exists is already reset
+func resetVF(vf *state.VF) error {
+	if vfGone(vf) {
+		logging.Debugf("resetVF vf %d of %s, %s, no longer exists", vf.VFID, vf.PFName, vf.PCIAddr)
+		return nil
 	}
 
-	netlinkExpected, err := utils.ShouldHaveNetlink(conf.Master, conf.DeviceInfo.Vfid)
-	if err != nil {
-		logging.Debugf("releaseVF utils.ShouldHaveNetlink failed ifname %s vf %d error %v", conf.Master, conf.DeviceInfo.Vfid, err)
-		return fmt.Errorf("failed to determine if interface should have netlink device: %v", err)
+	if vf.SpoofChk != "" {
+		if err := utils.SetVfSpoofchk(vf.PFName, vf.VFID, vf.Orig.Spoofchk); err != nil {
+			return err
+		}
 	}
-	if !netlinkExpected {
+	if vf.Trust != "" {
+		if err := utils.SetVfTrust(vf.PFName, vf.VFID, vf.Orig.Trust); err != nil {
+			return err
+		}
+	}
+	if vf.LinkState != "" {
+		if err := utils.SetVfLinkState(vf.PFName, vf.VFID, vf.Orig.LinkState); err != nil {
+			return err
+		}
+	}
+	// a zero rate leaves the VF unlimited
+	if vf.MinTxRate != 0 || vf.MaxTxRate != 0 {
+		if err := utils.SetVfRate(vf.PFName, vf.VFID, 0, 0); err != nil {
+			return err
+		}
+	}
+
+	if vf.Vlan == 0 && vf.MAC == "" {
 		return nil
 	}
 
-	initns, err := ns.GetCurrentNS()
+	drv, err := vfdriver.ForDevice(vf.PCIAddr)
 	if err != nil {
-		logging.Debugf("releaseVF ns.GetCurrentNS failed error %v", err)
-		return fmt.Errorf("failed to get init netns: %v", err)
+		return err
 	}
 
-	if err = netns.Set(); err != nil {
-		logging.Debugf("releaseVF netns.Set failed error %v", err)
-		return fmt.Errorf("failed to enter netns %q: %v", netns, err)
+	if vf.Vlan != 0 {
+		if err = drv.ResetVlan(vf.PFName, vf.VFID); err != nil {
+			logging.Debugf("resetVF ResetVlan failed vf %d error %v", vf.VFID, err)
+			return err
+		}
 	}
 
-	if conf.L2Mode != false {
-		//check for the shared vf net interface
-		ifName := podifName + "d1"
-		_, err := netlink.LinkByName(ifName)
+	if vf.MAC != "" {
+		hwaddr, err := net.ParseMAC(vf.Orig.MAC)
 		if err != nil {
-			//logging.Debugf("releaseVF netlink.LinkByname failed not shared ifname %s %v", ifName, err)
-			//return fmt.Errorf("unable to get shared PF device: %v", err)
-			conf.Sharedvf = false
-		} else {
-			logging.Debugf("releaseVF netlink.LinkByname true and is shared ifname %s %v", ifName, err)
-			conf.Sharedvf = true
+			return fmt.Errorf("failed to parse the original mac %q of vf %d: %v", vf.Orig.MAC, vf.VFID, err)
+		}
+		if err = drv.SetMAC(vf.PFName, vf.VFID, hwaddr); err != nil {
+			logging.Debugf("resetVF SetMAC failed vf %d error %v", vf.VFID, err)
+			return fmt.Errorf("failed to restore mac %s of vf %d: %v", vf.Orig.MAC, vf.VFID, err)
 		}
 	}
 
-	for i := 1; i <= config.MaxSharedVf; i++ {
-		ifName := podifName
-		pfName := conf.Master
-		if i == config.MaxSharedVf {
-			ifName = podifName + fmt.Sprintf("d%d", i-1)
-			pfName, err = utils.GetSharedPF(conf.Master)
+	return nil
+}
+
+// releaseDPDKVF binds the VF back to the kernel driver recorded at ADD
+func releaseDPDKVF(vf *state.VF) error {
+	dc := *vf.DPDKConf
+	// the VF goes back to the driver it was bound to before ADD
+	if vf.Orig.Driver != "" && !utils.IsUserspaceDriver(vf.Orig.Driver) {
+		dc.KDriver = vf.Orig.Driver
+	}
+
+	if vfGone(vf) {
+		logging.Debugf("releaseDPDKVF %s no longer exists", vf.PCIAddr)
+		return nil
+	}
+	if driver, err := utils.GetDriverName(vf.PCIAddr); err == nil && driver == dc.KDriver {
+		logging.Debugf("releaseDPDKVF %s already bound to %s", vf.PCIAddr, driver)
+		return nil
+	}
+
+	logging.Debugf("releaseDPDKVF unbind dpdk : pcieaddr %s kdriver %s dpdkdriver %s dpdktool %s vfid %d", dc.PCIaddr, dc.KDriver, dc.DPDKDriver, dc.DPDKtool, vf.VFID)
+
+	drv, err := vfdriver.ForDevice(vf.PCIAddr)
+	if err != nil {
+		return err
+	}
+	if err = drv.UnbindDPDK(&dc); err != nil {
+		logging.Debugf("releaseDPDKVF UnbindDPDK failed pcie %s err %v", vf.PCIAddr, err)
+		return fmt.Errorf("DPDK: failed to bind %s to kernel space: %s", vf.PCIAddr, err)
+	}
+
+	return nil
+}
+
+// moveVFToHost moves the VF netdevs of vf from netns back to the init netns
+func moveVFToHost(vf *state.VF, netns ns.NetNS) error {
+	initns, err := ns.GetCurrentNS()
+	if err != nil {
+		logging.Debugf("moveVFToHost ns.GetCurrentNS failed error %v", err)
+		return fmt.Errorf("failed to get init netns: %v", err)
+	}
+	defer initns.Close()
+
+	return withHostHandle(func(host *netlink.Handle) error {
+		return withHandle(netns, func(pod *netlink.Handle) error {
+			for i := 0; i < config.MaxSharedVf; i++ {
+				ifName := vf.PodIfName
+				if i > 0 {
+					// the netdev of a VF shared by two PFs
+					ifName = vf.PodIfName + fmt.Sprintf("d%d", i)
+				}
+
+				vfDev, err := pod.LinkByName(ifName)
+				if err != nil {
+					// moved back by an earlier DEL, or never moved by ADD
+					logging.Debugf("moveVFToHost netlink.LinkByName ifname %s not found %v", ifName, err)
+					continue
+				}
+
+				// device name in init netns
+				devName, err := hostIfName(vf, i, vfDev, pod, host)
+				if err != nil {
+					return err
+				}
+
+				if err = restoreNetdev(pod, vf, ifName); err != nil {
+					return err
+				}
+
+				// shutdown VF device
+				if err = pod.LinkSetDown(vfDev); err != nil {
+					logging.Debugf("moveVFToHost netlink.LinkSetDown error ifname %s %v", ifName, err)
+					return fmt.Errorf("failed to down vf device %q: %v", ifName, err)
+				}
+
+				// rename VF device
+				if err = renameLink(pod, ifName, devName); err != nil {
+					logging.Debugf("moveVFToHost renameLink error ifname %s %s %v", ifName, devName, err)
+					return fmt.Errorf("failed to rename vf device %q to %q: %v", ifName, devName, err)
+				}
+
+				// move VF device to init netns
+				if err = pod.LinkSetNsFd(vfDev, int(initns.Fd())); err != nil {
+					logging.Debugf("moveVFToHost netlink.LinkSetNsFd error ifname %s %v", ifName, err)
+					return fmt.Errorf("failed to move vf device %q to init netns: %v", ifName, err)
+				}
+
+				// reset vlan of the shared PF, the VF one is reset by releaseVF
+				if i > 0 && vf.Vlan != 0 {
+					pfName, err := utils.GetSharedPF(vf.PFName)
+					if err != nil {
+						return fmt.Errorf("failed to look up shared PF device: %v", err)
+					}
+					if err = resetVfVlan(pfName, devName); err != nil {
+						logging.Debugf("moveVFToHost resetVfVlan error ifname %s %v", devName, err)
+						return fmt.Errorf("failed to reset vlan: %v", err)
+					}
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// restoreHostVF gives the VF netdevs of vf their host name and their original
+// MAC and MTU back once the kernel returned them to the init netns under their
+// pod names, as it does when the pod netns is destroyed before DEL
+func restoreHostVF(vf *state.VF) error {
+	links, err := utils.GetVFLinkNames(vf.PFName, vf.VFID)
+	if err != nil {
+		logging.Debugf("restoreHostVF no netdev of vf %d of %s in init netns: %v", vf.VFID, vf.PFName, err)
+		return nil
+	}
+
+	return withHostHandle(func(host *netlink.Handle) error {
+		for _, ifName := range links {
+			// the netdev of a VF shared by two PFs is not the pod
+			// interface itself
+			i := 0
+			if len(links) > 1 && ifName != vf.PodIfName && ifName != vf.HostIfName {
+				i = 1
+			}
+
+			vfDev, err := host.LinkByName(ifName)
 			if err != nil {
-				logging.Debugf("releaseVF utils.GetSharedPF error shouldn't be here ifname %s %v", conf.Master, err)
-				return fmt.Errorf("failed to look up shared PF device: %v", err)
+				return fmt.Errorf("failed to lookup vf device %q: %v", ifName, err)
 			}
-		}
 
-		// get VF device
-		vfDev, err := netlink.LinkByName(ifName)
-		if err != nil {
-			logging.Debugf("releaseVF netlink.LinkByName error ifname %s %v", conf.Master, err)
-			return fmt.Errorf("failed to lookup vf device %q: %v", ifName, err)
-		}
+			devName, err := hostIfName(vf, i, vfDev, nil, host)
+			if err != nil {
+				return err
+			}
 
-		// device name in init netns
-		index := vfDev.Attrs().Index
-		devName := fmt.Sprintf("dev%d", index)
+			if err = restoreNetdev(host, vf, ifName); err != nil {
+				return err
+			}
 
-		// shutdown VF device
-		if err = netlink.LinkSetDown(vfDev); err != nil {
-			logging.Debugf("releaseVF netlink.LinkSetDown error ifname %s %v %v", ifName, vfDev, err)
-			return fmt.Errorf("failed to down vf device %q: %v", ifName, err)
+			if devName != ifName {
+				if err = host.LinkSetDown(vfDev); err != nil {
+					return fmt.Errorf("failed to down vf device %q: %v", ifName, err)
+				}
+				if err = renameLink(host, ifName, devName); err != nil {
+					return fmt.Errorf("failed to rename vf device %q to %q: %v", ifName, devName, err)
+				}
+			}
+
+			// reset vlan of the shared PF, the VF one is reset by releaseVF
+			if i > 0 && vf.Vlan != 0 {
+				pfName, err := utils.GetSharedPF(vf.PFName)
+				if err != nil {
+					return fmt.Errorf("failed to look up shared PF device: %v", err)
+				}
+				if err = resetVfVlan(pfName, devName); err != nil {
+					return fmt.Errorf("failed to reset vlan: %v", err)
+				}
+			}
 		}
+		return nil
+	})
+}
 
-		// rename VF device
-		err = renameLink(ifName, devName)
+// restoreNetdev gives the VF netdev ifName of vf, in the netns of h, its MAC
+// and MTU found at ADD back
+func restoreNetdev(h *netlink.Handle, vf *state.VF, ifName string) error {
+	if vf.MAC != "" && vf.Orig.NetdevMAC != "" {
+		hwaddr, err := net.ParseMAC(vf.Orig.NetdevMAC)
 		if err != nil {
-			logging.Debugf("releaseVF renameLink error ifname %s %s %v", ifName, devName, err)
-			return fmt.Errorf("failed to rename vf device %q to %q: %v", ifName, devName, err)
+			return fmt.Errorf("failed to parse the original mac %q of %q: %v", vf.Orig.NetdevMAC, ifName, err)
 		}
+		if err = setLinkHardwareAddr(h, ifName, hwaddr); err != nil {
+			return err
+		}
+	}
 
-		// move VF device to init netns
-		if err = netlink.LinkSetNsFd(vfDev, int(initns.Fd())); err != nil {
-			logging.Debugf("releaseVF netlink.LinkSetNsFd  error ifname %s %v %v", ifName, vfDev, err)
-			return fmt.Errorf("failed to move vf device %q to init netns: %v", ifName, err)
+	if vf.MTU != 0 && vf.Orig.MTU != 0 {
+		if err := setLinkMTU(h, ifName, vf.Orig.MTU); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// reset vlan
-		if conf.Vlan != 0 {
-			err = initns.Do(func(_ ns.NetNS) error {
-				return resetVfVlan(pfName, devName)
-			})
-			if err != nil {
-				logging.Debugf("releaseVF resetVfVlan  error ifname %s %v %v", pfName, devName, err)
-				return fmt.Errorf("failed to reset vlan: %v", err)
+// hostIfName returns the name the netdev vfDev of vf, the i-th one of a shared
+// VF, is given back in the init netns: its name found at ADD unless the index
+// hostNamePolicy is set, dev<ifindex> otherwise. The first name not used by
+// another netdev of the pod netns of pod or of the init netns of host is
+// picked. With pod nil, vfDev is already in the init netns.
+func hostIfName(vf *state.VF, i int, vfDev netlink.Link, pod, host *netlink.Handle) (string, error) {
+	index := vfDev.Attrs().Index
+	candidates := []string{}
+	if i == 0 && vf.HostIfName != "" && vf.HostNamePolicy != config.HostNamePolicyIndex {
+		candidates = append(candidates, vf.HostIfName)
+	}
+	candidates = append(candidates, fmt.Sprintf("dev%d", index))
+	// ifindexes are only unique within a netns
+	for n := 1; n < 10; n++ {
+		candidates = append(candidates, fmt.Sprintf("dev%d_%d", index, n))
+	}
+
+	for _, name := range candidates {
+		if pod != nil {
+			if link, err := pod.LinkByName(name); err == nil && link.Attrs().Index != index {
+				logging.Debugf("hostIfName %s is used in the pod netns", name)
+				continue
 			}
 		}
-
-		//break the loop, if the namespace has no shared vf net interface
-		if conf.Sharedvf != true {
-			break
+		if link, err := host.LinkByName(name); err == nil && (pod != nil || link.Attrs().Index != index) {
+			logging.Debugf("hostIfName %s is used in the init netns", name)
+			continue
 		}
+		return name, nil
 	}
 
-	logging.Debugf("releaseVF in DPDKMode is complete")
-	return nil
+	return "", fmt.Errorf("no free name in init netns for vf device %q", vf.PodIfName)
 }
 
 func resetVfVlan(pfName, vfName string) error {
@@ -396,14 +869,8 @@ func resetVfVlan(pfName, vfName string) error {
 		return fmt.Errorf("failed to get VF id for %s", vfName)
 	}
 
-	pfLink, err := netlink.LinkByName(pfName)
-	if err != nil {
-		logging.Debugf("resetVfVlan failed master device %s not found", pfName)
-		return fmt.Errorf("master device %s not found", pfName)
-	}
-
-	if err = netlink.LinkSetVfVlan(pfLink, vf, 0); err != nil {
-		logging.Debugf("resetVfVlan failed in netlink.LinkSetVfVlan %s vf %d", pfName, vf)
+	if err = utils.SetVfVlan(pfName, vf, 0, 0, utils.VlanProto8021Q); err != nil {
+		logging.Debugf("resetVfVlan failed in utils.SetVfVlan %s vf %d", pfName, vf)
 		return fmt.Errorf("failed to reset vlan tag for vf %d: %v", vf, err)
 	}
 
@@ -412,15 +879,15 @@ func resetVfVlan(pfName, vfName string) error {
 	return nil
 }
 
-func renameLink(curName, newName string) error {
-	link, err := netlink.LinkByName(curName)
+func renameLink(h *netlink.Handle, curName, newName string) error {
+	link, err := h.LinkByName(curName)
 	if err != nil {
 		logging.Debugf("renameLink failed in netlink.LinkByName %q: %v", curName, err)
 		return fmt.Errorf("failed to lookup device %q: %v", curName, err)
 	}
 
 	//return netlink.LinkSetName(link, newName)
-	err = netlink.LinkSetName(link, newName)
+	err = h.LinkSetName(link, newName)
 	if err != nil {
 		logging.Debugf("renameLink failed in netlink.LinkSetName curName %q newName %s %v", curName, newName, err)
 	}
@@ -430,16 +897,106 @@ func renameLink(curName, newName string) error {
 	return err
 }
 
-func setUpLink(ifName string) error {
-	link, err := netlink.LinkByName(ifName)
+func setLinkHardwareAddr(h *netlink.Handle, ifName string, hwaddr net.HardwareAddr) error {
+	link, err := h.LinkByName(ifName)
+	if err != nil {
+		logging.Debugf("setLinkHardwareAddr failed in netlink.LinkByName %q: %v", ifName, err)
+		return fmt.Errorf("failed to lookup device %q: %v", ifName, err)
+	}
+
+	if err = h.LinkSetHardwareAddr(link, hwaddr); err != nil {
+		logging.Debugf("setLinkHardwareAddr failed in netlink.LinkSetHardwareAddr ifname %s %v", ifName, err)
+		return fmt.Errorf("failed to set mac %s of device %q: %v", hwaddr, ifName, err)
+	}
+	return nil
+}
+
+func setLinkMTU(h *netlink.Handle, ifName string, mtu int) error {
+	link, err := h.LinkByName(ifName)
+	if err != nil {
+		logging.Debugf("setLinkMTU failed in netlink.LinkByName %q: %v", ifName, err)
+		return fmt.Errorf("failed to lookup device %q: %v", ifName, err)
+	}
+
+	if err = h.LinkSetMTU(link, mtu); err != nil {
+		logging.Debugf("setLinkMTU failed in netlink.LinkSetMTU ifname %s %v", ifName, err)
+		return fmt.Errorf("failed to set mtu %d of device %q: %v", mtu, ifName, err)
+	}
+	return nil
+}
+
+func setUpLink(h *netlink.Handle, ifName string) error {
+	link, err := h.LinkByName(ifName)
 	if err != nil {
 		logging.Debugf("setUpLink failed in netlink.LinkByName %q: %v", ifName, err)
 		return fmt.Errorf("failed to set up device %q: %v", ifName, err)
 	}
 
-	err = netlink.LinkSetUp(link)
+	err = h.LinkSetUp(link)
 	if err != nil {
 		logging.Debugf("setUpLink failed in netlink.LinkSetUp ifname %s %v", ifName, err)
 	}
 	return err
 }
+
+// checkPfMtu verifies that the PF pfLink of the init netns of host can carry
+// the MTU of conf, raising the PF MTU if adjustPfMtu is set. The MTU the PF had
+// before is recorded in the allocator of the node, under its lock, so that the
+// last attachment holding a VF of the PF restores it.
+func checkPfMtu(host *netlink.Handle, pfLink netlink.Link, conf *sriovtypes.NetConf) error {
+	if conf.MTU <= pfLink.Attrs().MTU {
+		return nil
+	}
+	if !conf.AdjustPfMtu {
+		return fmt.Errorf("mtu %d of vf %d is above the mtu %d of PF %q", conf.MTU, conf.DeviceInfo.Vfid, pfLink.Attrs().MTU, conf.Master)
+	}
+
+	alloc, err := allocator.Open(conf.CNIDir)
+	if err != nil {
+		return err
+	}
+	defer alloc.Close()
+
+	// another ADD may have raised the PF meanwhile
+	if pfLink, err = host.LinkByName(conf.Master); err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+	pfMTU := pfLink.Attrs().MTU
+	if conf.MTU <= pfMTU {
+		return nil
+	}
+
+	logging.Debugf("checkPfMtu raising mtu of PF %s from %d to %d", conf.Master, pfMTU, conf.MTU)
+	if err = host.LinkSetMTU(pfLink, conf.MTU); err != nil {
+		return fmt.Errorf("failed to raise mtu of PF %q to %d: %v", conf.Master, conf.MTU, err)
+	}
+	if err = alloc.RaisePfMtu(conf.Master, pfMTU, conf.MTU); err != nil {
+		// the PF is not known to be raised, it is left as it was
+		if lowerErr := host.LinkSetMTU(pfLink, pfMTU); lowerErr != nil {
+			logging.Debugf("checkPfMtu failed to lower mtu of PF %s back to %d: %v", conf.Master, pfMTU, lowerErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restorePfMtu lowers the MTU of the PF pfName back to origMTU if it is still
+// the MTU raised to by ADD
+func restorePfMtu(pfName string, raisedMTU, origMTU int) error {
+	return withHostHandle(func(host *netlink.Handle) error {
+		pfLink, err := host.LinkByName(pfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup master %q: %v", pfName, err)
+		}
+		if pfLink.Attrs().MTU != raisedMTU {
+			logging.Debugf("restorePfMtu mtu of PF %s changed to %d, left as is", pfName, pfLink.Attrs().MTU)
+			return nil
+		}
+
+		logging.Debugf("restorePfMtu lowering mtu of PF %s from %d to %d", pfName, raisedMTU, origMTU)
+		if err = host.LinkSetMTU(pfLink, origMTU); err != nil {
+			return fmt.Errorf("failed to restore mtu of PF %q to %d: %v", pfName, origMTU, err)
+		}
+		return nil
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/intel/sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -11,3 +12,12 @@ func TestSriov(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "sriov Suite")
 }
+
+var _ = BeforeSuite(func() {
+	// create test sys tree
+	Expect(utils.CreateTmpSysFs()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(utils.RemoveTmpSysFs()).To(Succeed())
+})
@@ -1,16 +1,161 @@
 package main
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/state"
+	"github.com/intel/sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
-	//	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
 )
 
-// TODO - implement this tests
-
-// Dummy tests
 var _ = Describe("sriov Operations", func() {
-	Context("Dummy check", func() {
-		It("should do nothing", func() {
+	Context("Checking releaseVF function with the pod netns gone", func() {
+		// the kernel returned the VF netdev of 0000:af:02.0 to the init
+		// netns under its pod name, a veth stands for it
+		const podIfName, hostIfName = "sriovt-net1", "sriovt-host"
+		var vfNetDir string
+		var vf *state.VF
+		BeforeEach(func() {
+			if os.Getuid() != 0 {
+				Skip("creating netdevs requires root")
+			}
+			vfNetDir = filepath.Join(utils.NetDirectory, "enp175s0f0", "device", "virtfn0", "net")
+			Expect(os.Rename(filepath.Join(vfNetDir, "enp175s2"), filepath.Join(vfNetDir, podIfName))).To(Succeed())
+
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: podIfName, MTU: 9000}, PeerName: "sriovt-peer"}
+			Expect(netlink.LinkAdd(veth)).To(Succeed())
+
+			vf = &state.VF{
+				PFName:     "enp175s0f0",
+				VFID:       0,
+				PCIAddr:    "0000:af:02.0",
+				HostIfName: hostIfName,
+				PodIfName:  podIfName,
+				MTU:        9000,
+				Orig:       state.OrigVF{MTU: 1500},
+			}
+		})
+		AfterEach(func() {
+			for _, name := range []string{podIfName, hostIfName} {
+				if link, err := netlink.LinkByName(name); err == nil {
+					netlink.LinkDel(link)
+				}
+			}
+			for _, name := range []string{podIfName, hostIfName} {
+				os.Rename(filepath.Join(vfNetDir, name), filepath.Join(vfNetDir, "enp175s2"))
+			}
+		})
+		It("Assuming netdev left under its pod name", func() {
+			Expect(releaseVF(vf, "cid1", nil)).To(Succeed())
+
+			_, err := netlink.LinkByName(podIfName)
+			Expect(err).To(HaveOccurred(), "The pod name should not be left on the host")
+			link, err := netlink.LinkByName(hostIfName)
+			Expect(err).NotTo(HaveOccurred(), "The original host name should be restored")
+			Expect(link.Attrs().MTU).To(Equal(1500))
+		})
+		It("Assuming netdev already restored by an earlier DEL", func() {
+			Expect(releaseVF(vf, "cid1", nil)).To(Succeed())
+			// sysfs follows the rename
+			Expect(os.Rename(filepath.Join(vfNetDir, podIfName), filepath.Join(vfNetDir, hostIfName))).To(Succeed())
+			Expect(releaseVF(vf, "cid1", nil)).To(Succeed())
+
+			_, err := netlink.LinkByName(hostIfName)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming mac set by ADD", func() {
+			vf.MAC = "66:77:88:99:aa:bb"
+			vf.Orig.NetdevMAC = "66:77:88:99:aa:01"
+			// the PF side is reset by resetVF, only the netdev is checked
+			Expect(restoreHostVF(vf)).To(Succeed())
+
+			link, err := netlink.LinkByName(hostIfName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.Attrs().HardwareAddr).To(Equal(net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0x01}))
+		})
+	})
+	Context("Checking releaseVF function with the VF gone", func() {
+		It("Assuming PF driver reloaded since ADD", func() {
+			// 0000:af:07.0 is missing from sysfs
+			vf := &state.VF{
+				PFName:     "enp175s0f0",
+				VFID:       7,
+				PCIAddr:    "0000:af:07.0",
+				HostIfName: "enp175s7",
+				PodIfName:  "net1",
+				Vlan:       100,
+				MAC:        "66:77:88:99:aa:bb",
+				SpoofChk:   "off",
+				DPDKConf:   &dpdk.Conf{PCIaddr: "0000:af:07.0", KDriver: "i40evf", DPDKDriver: "igb_uio"},
+				DPDKBound:  true,
+				Orig:       state.OrigVF{MAC: "66:77:88:99:aa:01", Spoofchk: true},
+			}
+			Expect(releaseVF(vf, "cid1", nil)).To(Succeed(), "A VF which no longer exists should be released")
+		})
+	})
+	Context("Checking releaseLegacyDPDKVFs function", func() {
+		var dataDir string
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "sriovplugin-data-")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
+		writeScratch := func(name, conf string) string {
+			path := filepath.Join(dataDir, name)
+			Expect(ioutil.WriteFile(path, []byte(conf), 0600)).To(Succeed())
+			return path
+		}
+		It("Assuming scratch files of an ADD predating the state store", func() {
+			// 0000:af:06.0 is already back on i40evf, 0000:af:07.0 is gone
+			bound := writeScratch("cid1-net1-0", `{"pci_addr": "0000:af:06.0", "ifname": "net1-0", "kernel_driver": "i40evf", "dpdk_driver": "igb_uio", "vfid": 0}`)
+			gone := writeScratch("cid1-net1-1", `{"pci_addr": "0000:af:07.0", "ifname": "net1-1", "kernel_driver": "i40evf", "dpdk_driver": "igb_uio", "vfid": 7}`)
+			stateFile := writeScratch("cid1-net1-a.json", `{}`)
+			Expect(releaseLegacyDPDKVFs(dataDir, "cid1", "net1")).To(Succeed())
+
+			for _, path := range []string{bound, gone} {
+				_, err := os.Stat(path)
+				Expect(os.IsNotExist(err)).To(BeTrue(), "The scratch file of a released VF should be removed")
+			}
+			Expect(stateFile).To(BeAnExistingFile(), "The state of another attachment should be left alone")
+		})
+		It("Assuming malformed scratch file", func() {
+			writeScratch("cid1-net1-0", `{"pci_addr": `)
+			Expect(releaseLegacyDPDKVFs(dataDir, "cid1", "net1")).NotTo(Succeed())
+		})
+		It("Assuming no scratch file", func() {
+			Expect(releaseLegacyDPDKVFs(dataDir, "cid1", "net1")).To(Succeed())
+		})
+	})
+	Context("Checking sortLinksByIndex function", func() {
+		It("Assuming links of the netns", func() {
+			if os.Getuid() != 0 {
+				Skip("creating netdevs requires root")
+			}
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "sriovt-a"}, PeerName: "sriovt-b"}
+			Expect(netlink.LinkAdd(veth)).To(Succeed())
+			defer netlink.LinkDel(veth)
+
+			names := []string{"sriovt-a", "lo"}
+			Expect(withHostHandle(func(h *netlink.Handle) error {
+				return sortLinksByIndex(h, names)
+			})).To(Succeed())
+			Expect(names).To(Equal([]string{"lo", "sriovt-a"}))
+		})
+		It("Assuming link gone", func() {
+			names := []string{"lo", "sriovt-missing"}
+			err := withHostHandle(func(h *netlink.Handle) error {
+				return sortLinksByIndex(h, names)
+			})
+			Expect(err).To(HaveOccurred(), "A missing link should be reported instead of panicking")
 		})
 	})
 })
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/intel/multus-cni/logging"
+	"github.com/intel/sriov-cni/pkg/dpdk"
+	"github.com/intel/sriov-cni/pkg/state"
+	sriovtypes "github.com/intel/sriov-cni/pkg/types"
+	"github.com/intel/sriov-cni/pkg/utils"
+	"github.com/intel/sriov-cni/pkg/vfdriver"
+	"github.com/vishvananda/netlink"
+)
+
+// newVFState records the VF of conf as found before it is set up as the pod
+// interface podIfName
+func newVFState(conf *sriovtypes.NetConf, podIfName string) (*state.VF, error) {
+	vf := &state.VF{
+		PFName:         conf.Master,
+		VFID:           conf.DeviceInfo.Vfid,
+		PCIAddr:        conf.DeviceInfo.PCIaddr,
+		PodIfName:      podIfName,
+		Vlan:           conf.Vlan,
+		VlanQoS:        conf.VlanQoS,
+		VlanProto:      conf.VlanProto,
+		SpoofChk:       conf.SpoofChk,
+		Trust:          conf.Trust,
+		LinkState:      conf.LinkState,
+		MinTxRate:      conf.MinTxRate,
+		MaxTxRate:      conf.MaxTxRate,
+		MTU:            conf.MTU,
+		HostNamePolicy: conf.HostNamePolicy,
+		L2Mode:         conf.L2Mode,
+		DPDKConf:       conf.DPDKConf,
+	}
+
+	if conf.MAC != "" {
+		hwaddr, err := net.ParseMAC(conf.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse mac %q: %v", conf.MAC, err)
+		}
+		vf.MAC = hwaddr.String()
+	}
+
+	orig, err := utils.GetVfState(conf.Master, conf.DeviceInfo.Vfid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the original settings of VF %d of %q: %v", conf.DeviceInfo.Vfid, conf.Master, err)
+	}
+	vf.Orig = state.OrigVF{
+		MAC:       orig.MAC.String(),
+		Vlan:      orig.Vlan,
+		Qos:       orig.Qos,
+		Spoofchk:  orig.Spoofchk,
+		Trust:     orig.Trust,
+		LinkState: orig.LinkState,
+	}
+
+	// an unbound VF has neither a driver nor a netdev
+	if driver, err := utils.GetDriverName(conf.DeviceInfo.PCIaddr); err == nil {
+		vf.Orig.Driver = driver
+	}
+	if names, err := utils.GetVFLinkNames(conf.Master, conf.DeviceInfo.Vfid); err == nil && len(names) > 0 {
+		vf.HostIfName = names[0]
+		if link, err := netlink.LinkByName(vf.HostIfName); err == nil {
+			vf.Orig.NetdevMAC = link.Attrs().HardwareAddr.String()
+			vf.Orig.MTU = link.Attrs().MTU
+		}
+	}
+
+	if conf.DPDKMode {
+		drv, err := vfdriver.ForDevice(conf.DeviceInfo.PCIaddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine the DPDK binding of VF %q: %v", conf.DeviceInfo.PCIaddr, err)
+		}
+		vf.DPDKBound = drv.NeedsDPDKBind()
+		// a VF already bound to a userspace driver is handed over as it
+		// is and left bound on release
+		if utils.IsUserspaceDriver(vf.Orig.Driver) {
+			vf.DPDKBound = false
+		}
+		// setupVF adds the VFs which are not bound to DPDK as L2
+		vf.L2Mode = vf.L2Mode || !vf.DPDKBound
+	}
+
+	return vf, nil
+}
+
+// fillDPDKConf completes the DPDK configuration of conf with the VF moved
+// into the pod as podIfName. Without kernel_driver, the kernel driver the VF
+// is bound to is recorded so that DEL binds it back.
+func fillDPDKConf(conf *sriovtypes.NetConf, podIfName string) error {
+	if !conf.DPDKMode || conf.DeviceInfo == nil {
+		return nil
+	}
+	conf.DPDKConf.PCIaddr = conf.DeviceInfo.PCIaddr
+	conf.DPDKConf.Ifname = podIfName
+	conf.DPDKConf.VFID = conf.DeviceInfo.Vfid
+
+	if conf.DPDKConf.KDriver != "" {
+		return nil
+	}
+	driver, err := utils.GetDriverName(conf.DeviceInfo.PCIaddr)
+	if err != nil {
+		return fmt.Errorf("dpdk kernel_driver is required for VF %q which is not bound to a driver: %v", conf.DeviceInfo.PCIaddr, err)
+	}
+	// a VF already bound to a userspace driver is left bound on release
+	if !utils.IsUserspaceDriver(driver) {
+		conf.DPDKConf.KDriver = driver
+	}
+	logging.Debugf("fillDPDKConf detected driver %s of VF %s", driver, conf.DeviceInfo.PCIaddr)
+	return nil
+}
+
+// releaseLegacyDPDKVFs binds the VFs of the attachment ifName of container cid
+// back to their kernel driver from the DPDK scratch files written by the
+// plugin versions predating the state store. They are named
+// <cid>-<ifName>-<index> in dataDir and hold the dpdk.Conf of one VF, each
+// is removed once its VF is released.
+func releaseLegacyDPDKVFs(dataDir, cid, ifName string) error {
+	prefix := cid + "-" + ifName + "-"
+	paths, err := filepath.Glob(filepath.Join(dataDir, prefix+"*"))
+	if err != nil {
+		return fmt.Errorf("failed to look up the DPDK scratch files of %q: %v", prefix, err)
+	}
+
+	for _, path := range paths {
+		// the state files of other attachments may share the prefix
+		if _, err = strconv.Atoi(strings.TrimPrefix(filepath.Base(path), prefix)); err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read the DPDK scratch file %q: %v", path, err)
+		}
+		dc := &dpdk.Conf{}
+		if err = json.Unmarshal(data, dc); err != nil {
+			return fmt.Errorf("failed to parse the DPDK scratch file %q: %v", path, err)
+		}
+
+		logging.Debugf("releaseLegacyDPDKVFs releasing VF %s of %s", dc.PCIaddr, path)
+		vf := &state.VF{
+			VFID:      dc.VFID,
+			PCIAddr:   dc.PCIaddr,
+			PodIfName: dc.Ifname,
+			DPDKConf:  dc,
+			DPDKBound: true,
+		}
+		if err = releaseDPDKVF(vf); err != nil {
+			return err
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove the DPDK scratch file %q: %v", path, err)
+		}
+	}
+	return nil
+}